@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Timeout for a single request, including reading the response
+	apiTimeout = 30 * time.Second
+
+	// Retries use exponential backoff with full jitter between attempts
+	apiMaxAttempts = 5
+	apiBaseBackoff = 500 * time.Millisecond
+	apiMaxBackoff  = 30 * time.Second
+
+	// Longest Retry-After we wait out, anything longer fails the request
+	apiMaxRetryAfter = 2 * time.Minute
+)
+
+// Errors returned by provider calls, wrapped in an APIError. Check them
+// with errors.Is.
+var (
+	ErrAuth        = errors.New("not authorized")
+	ErrNotFound    = errors.New("not found")
+	ErrRateLimited = errors.New("rate limited")
+	ErrQuota       = errors.New("quota exceeded")
+	ErrBadTrack    = errors.New("track refused")
+)
+
+// Spotify errors have no reason, these messages mean a track ID it doesn't
+// know
+var spotifyBadTrack = []string{"invalid base62 id", "non-existing id"}
+
+// APIError is a non-2xx response from a provider.
+type APIError struct {
+	Service    string
+	StatusCode int
+	Status     string
+	// Provider reason, such as YouTube's "quotaExceeded"
+	Reason  string
+	Message string
+	// One of the Err values above, or nil for other failures
+	Kind error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Service, e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// Minimum time between requests to each service, registered alongside the
+// service itself
+var rateLimits = map[string]time.Duration{}
+
+var (
+	limiters   = map[string]*RateLimiter{}
+	limitersMu sync.Mutex
+)
+
+func RegisterRateLimit(service string, perSecond float64) {
+	rateLimits[service] = time.Duration(float64(time.Second) / perSecond)
+}
+
+// RateLimiter spaces out requests to a service. It is shared by every
+// client of that service so concurrent callers stay under the limit.
+type RateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	clock    Clock
+	mu       sync.Mutex
+}
+
+func rateLimiterFor(service string) *RateLimiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiter, ok := limiters[service]
+	if !ok {
+		limiter = &RateLimiter{interval: rateLimits[service], clock: realClock{}}
+		limiters[service] = limiter
+	}
+	return limiter
+}
+
+// Wait blocks until the next request may be sent, or ctx is cancelled.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+	return sleep(ctx, l.clock, start.Sub(now))
+}
+
+// sleep waits for d on clock, returning early with the error of ctx when
+// it is cancelled.
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(d):
+		return nil
+	}
+}
+
+// Pause holds back every request until d has passed, used when the service
+// asks us to slow down.
+func (l *RateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := l.clock.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+}
+
+// APIClient sends JSON requests to a provider, retrying rate limited and
+// failed requests and turning error responses into an APIError.
+type APIClient struct {
+	service string
+	client  *http.Client
+	limiter *RateLimiter
+	// Daily quota of the service, nil when it has none
+	quota *QuotaLedger
+	clock Clock
+}
+
+func NewAPIClient(service string, config *Config, client *http.Client) *APIClient {
+	if client.Timeout == 0 {
+		client.Timeout = apiTimeout
+	}
+	return &APIClient{
+		service: service,
+		client:  client,
+		limiter: rateLimiterFor(service),
+		quota:   quotaLedgerFor(service, config.Quota),
+		clock:   realClock{},
+	}
+}
+
+// Do sends the request and returns the response for 2xx statuses. Rate
+// limited requests are always retried, but only GET and DELETE are retried
+// after network and server errors: an add or move may already have been
+// applied and repeating it would change the playlist twice. Cancelling ctx
+// stops the request and any wait for a retry, the rate limit or the quota.
+func (c *APIClient) Do(ctx context.Context, method string, url string, body interface{}) (*http.Response, error) {
+	// Encode request body once, it is resent on retries
+	var bodyJSON []byte
+	if body != nil {
+		var err error
+		bodyJSON, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling request body: %v", err)
+		}
+	}
+	idempotent := method == "GET" || method == "DELETE"
+
+	for attempt := 1; ; attempt++ {
+		var reader io.Reader
+		if bodyJSON != nil {
+			reader = bytes.NewReader(bodyJSON)
+		}
+
+		// Create request
+		req, err := http.NewRequestWithContext(ctx, method, url, reader)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
+
+		// Add headers, authorization is set by the OAuth client
+		if bodyJSON != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		// Make request, every attempt counts against the quota
+		if c.quota != nil {
+			if err := c.quota.Spend(ctx, method, url); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("error making request: %w", err)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || isAuthError(err) || !idempotent || attempt == apiMaxAttempts {
+				return nil, fmt.Errorf("error making request: %w", err)
+			}
+			if err := c.backoff(ctx, attempt, 0); err != nil {
+				return nil, fmt.Errorf("error making request: %w", err)
+			}
+			continue
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		apiErr := readAPIError(c.service, resp)
+		if errors.Is(apiErr, ErrQuota) && c.quota != nil {
+			// Spend refuses or waits for the reset from now on
+			c.quota.Exhaust()
+			if c.quota.wait {
+				attempt--
+				continue
+			}
+		}
+		retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), c.clock.Now())
+		retry := false
+		switch {
+		case errors.Is(apiErr, ErrRateLimited):
+			retry = !hasRetryAfter || retryAfter <= apiMaxRetryAfter
+		case resp.StatusCode >= 500:
+			retry = idempotent
+		}
+		if !retry || attempt == apiMaxAttempts {
+			return nil, apiErr
+		}
+		if err := c.backoff(ctx, attempt, retryAfter); err != nil {
+			return nil, fmt.Errorf("%w: stopped retrying: %w", apiErr, err)
+		}
+	}
+}
+
+// backoff waits before retrying, for retryAfter when the service gave one.
+// The limiter then holds back the next attempt.
+func (c *APIClient) backoff(ctx context.Context, attempt int, retryAfter time.Duration) error {
+	if retryAfter > 0 {
+		c.limiter.Pause(retryAfter)
+		return nil
+	}
+	limit := apiBaseBackoff << (attempt - 1)
+	if limit > apiMaxBackoff {
+		limit = apiMaxBackoff
+	}
+	return sleep(ctx, c.clock, rand.N(limit))
+}
+
+// readAPIError reads the error body of resp and classifies it. Spotify and
+// YouTube both wrap errors in an "error" object.
+func readAPIError(service string, resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	apiErr := &APIError{Service: service, StatusCode: resp.StatusCode, Status: resp.Status}
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) == nil {
+		apiErr.Message = body.Error.Message
+		if len(body.Error.Errors) > 0 {
+			apiErr.Reason = body.Error.Errors[0].Reason
+		}
+	}
+
+	switch {
+	case apiErr.Reason == "videoNotFound" || badSpotifyTrack(apiErr):
+		apiErr.Kind = ErrBadTrack
+	case resp.StatusCode == 401:
+		apiErr.Kind = ErrAuth
+	case resp.StatusCode == 404:
+		apiErr.Kind = ErrNotFound
+	case resp.StatusCode == 429:
+		apiErr.Kind = ErrRateLimited
+	case apiErr.Reason == "quotaExceeded" || apiErr.Reason == "dailyLimitExceeded":
+		apiErr.Kind = ErrQuota
+	case apiErr.Reason == "rateLimitExceeded" || apiErr.Reason == "userRateLimitExceeded":
+		apiErr.Kind = ErrRateLimited
+	}
+	return apiErr
+}
+
+func badSpotifyTrack(apiErr *APIError) bool {
+	if apiErr.StatusCode != 400 {
+		return false
+	}
+	message := strings.ToLower(apiErr.Message)
+	for _, known := range spotifyBadTrack {
+		if strings.Contains(message, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date, which is counted from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
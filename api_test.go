@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiResponse is one reply of the test server. Retry-After values starting
+// with "+" are an HTTP date that many seconds after the clock's start.
+type apiResponse struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+// apiServer answers with responses in turn, repeating the last one.
+type apiServer struct {
+	mu        sync.Mutex
+	responses []apiResponse
+	requests  int
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	response := s.responses[min(s.requests, len(s.responses)-1)]
+	s.requests++
+	s.mu.Unlock()
+
+	if response.retryAfter != "" {
+		if seconds, found := cutPlus(response.retryAfter); found {
+			w.Header().Set("Retry-After", daemonStart.Add(seconds).Format(http.TimeFormat))
+		} else {
+			w.Header().Set("Retry-After", response.retryAfter)
+		}
+	}
+	w.WriteHeader(response.status)
+	w.Write([]byte(response.body))
+}
+
+func cutPlus(value string) (time.Duration, bool) {
+	if value[0] != '+' {
+		return 0, false
+	}
+	d, err := time.ParseDuration(value[1:] + "s")
+	return d, err == nil
+}
+
+// newTestAPIClient returns a client of server that waits on clock.
+func newTestAPIClient(t *testing.T, server http.Handler, clock Clock) (*APIClient, string) {
+	t.Helper()
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return &APIClient{service: "test", client: ts.Client(), limiter: &RateLimiter{clock: clock}, clock: clock}, ts.URL
+}
+
+func TestAPIClientDo(t *testing.T) {
+	ok := apiResponse{status: 200, body: "{}"}
+	tests := []struct {
+		name      string
+		method    string
+		responses []apiResponse
+		requests  int
+		kind      error
+		status    int
+		waited    time.Duration
+	}{
+		{"success", "GET", []apiResponse{ok}, 1, nil, 0, 0},
+
+		// Rate limits wait for Retry-After, or back off without one
+		{"retry after seconds", "POST", []apiResponse{{status: 429, retryAfter: "7"}, ok}, 2, nil, 0, 7 * time.Second},
+		{"retry after date", "POST", []apiResponse{{status: 429, retryAfter: "+30"}, ok}, 2, nil, 0, 30 * time.Second},
+		{"retry after too long", "GET", []apiResponse{{status: 429, retryAfter: "600"}, ok}, 1, ErrRateLimited, 429, 0},
+		{"rate limited without retry after", "GET", []apiResponse{{status: 429}, ok}, 2, nil, 0, -1},
+
+		// Server errors are only retried for reads and deletes
+		{"server error retried", "GET", []apiResponse{{status: 502}, ok}, 2, nil, 0, -1},
+		{"server error until the limit", "DELETE", []apiResponse{{status: 500}}, apiMaxAttempts, nil, 500, -1},
+		{"server error on add", "POST", []apiResponse{{status: 503}, ok}, 1, nil, 503, 0},
+
+		// Error types
+		{"unauthorized", "GET", []apiResponse{{status: 401}}, 1, ErrAuth, 401, 0},
+		{"not found", "GET", []apiResponse{{status: 404}}, 1, ErrNotFound, 404, 0},
+		{"quota exceeded", "GET", []apiResponse{{status: 403, body: `{"error": {"message": "quota", "errors": [{"reason": "quotaExceeded"}]}}`}}, 1, ErrQuota, 403, 0},
+		{"bad track", "POST", []apiResponse{{status: 400, body: `{"error": {"status": 400, "message": "Invalid base62 id"}}`}}, 1, ErrBadTrack, 400, 0},
+		{"other client error", "PUT", []apiResponse{{status: 400, body: `{"error": {"message": "Bad range"}}`}}, 1, nil, 400, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &apiServer{responses: test.responses}
+			clock := &fakeClock{now: daemonStart}
+			client, url := newTestAPIClient(t, server, clock)
+
+			resp, err := client.Do(context.Background(), test.method, url, nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if server.requests != test.requests {
+				t.Errorf("%d requests sent, want %d", server.requests, test.requests)
+			}
+			if test.status == 0 {
+				if err != nil {
+					t.Fatalf("Do() = %v, want success", err)
+				}
+			} else {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != test.status {
+					t.Fatalf("Do() = %v, want a %d error", err, test.status)
+				}
+				if test.kind != nil && !errors.Is(err, test.kind) {
+					t.Errorf("Do() = %v, want %v", err, test.kind)
+				}
+				if test.kind == nil && apiErr.Kind != nil {
+					t.Errorf("Do() = %v, want no error type", err)
+				}
+			}
+			if waited := clock.Now().Sub(daemonStart); test.waited >= 0 && waited != test.waited {
+				t.Errorf("waited %s, want %s", waited, test.waited)
+			}
+		})
+	}
+}
+
+// cancelClock cancels a context as soon as anything waits on it.
+type cancelClock struct {
+	cancel context.CancelFunc
+}
+
+func (c cancelClock) Now() time.Time {
+	return daemonStart
+}
+
+func (c cancelClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	if d > 0 {
+		c.cancel()
+	} else {
+		ch <- daemonStart
+	}
+	return ch
+}
+
+func TestAPIClientCancelBackoff(t *testing.T) {
+	server := &apiServer{responses: []apiResponse{{status: 500}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, url := newTestAPIClient(t, server, cancelClock{cancel: cancel})
+
+	_, err := client.Do(ctx, "GET", url, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do() = %v, want it cancelled", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 500 {
+		t.Errorf("Do() = %v, want the 500 that was being retried", err)
+	}
+	if server.requests != 1 {
+		t.Errorf("%d requests sent, want 1", server.requests)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backups kept per playlist, older ones are removed
+const backupKeep = 20
+
+// backupClock times backups, tests stop it to take several in one second
+var backupClock Clock = realClock{}
+
+// Backup is the track list of a playlist saved before playlistty removed or
+// reordered anything in it.
+type Backup struct {
+	Name      string    `json:"name"`
+	Service   string    `json:"service"`
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	Tracks    []Track   `json:"tracks"`
+}
+
+func backupDir() string {
+	return filepath.Join(DataDir(), "backups")
+}
+
+// BackupPlaylist reads playlist from service and saves it as a backup.
+func BackupPlaylist(service MusicService, playlist string, reason string) (*Backup, error) {
+	current, err := service.ReadPlaylist(playlist)
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist for backup: %v", err)
+	}
+	return SaveBackup(service, playlist, current, reason)
+}
+
+// SaveBackup saves the already read contents of playlist as a backup.
+func SaveBackup(service MusicService, playlist string, current *Playlist, reason string) (*Backup, error) {
+	now := backupClock.Now()
+	backup := &Backup{
+		Name:      fmt.Sprintf("%s-%s-%s", service.Name(), playlist, now.Format("20060102-150405")),
+		Service:   service.Name(),
+		ID:        playlist,
+		Title:     current.Name,
+		Reason:    reason,
+		CreatedAt: now,
+		Tracks:    current.Tracks,
+	}
+
+	if err := os.MkdirAll(backupDir(), 0755); err != nil {
+		return nil, fmt.Errorf("error creating backup directory: %v", err)
+	}
+	// Never overwrite an earlier backup taken in the same second
+	path := filepath.Join(backupDir(), backup.Name+".json")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		backup.Name = fmt.Sprintf("%s-%s-%s-%d", service.Name(), playlist, now.Format("20060102-150405"), n)
+		path = filepath.Join(backupDir(), backup.Name+".json")
+	}
+	data, err := json.MarshalIndent(backup, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling backup: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("error writing backup: %v", err)
+	}
+	fmt.Printf("Backed up %d tracks of %s to %s\n", len(backup.Tracks), playlist, backup.Name)
+
+	// Drop the oldest backups of this playlist
+	backups, err := ListBackups()
+	if err != nil {
+		return nil, err
+	}
+	var same []*Backup
+	for _, other := range backups {
+		if other.Service == backup.Service && other.ID == backup.ID {
+			same = append(same, other)
+		}
+	}
+	for len(same) > backupKeep {
+		if err := os.Remove(filepath.Join(backupDir(), same[0].Name+".json")); err != nil {
+			return nil, fmt.Errorf("error removing old backup: %v", err)
+		}
+		same = same[1:]
+	}
+	return backup, nil
+}
+
+// LoadBackup reads a backup by name or file path.
+func LoadBackup(name string) (*Backup, error) {
+	path := name
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Join(backupDir(), strings.TrimSuffix(name, ".json")+".json")
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no backup %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup: %v", err)
+	}
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("error parsing backup: %v", err)
+	}
+	return &backup, nil
+}
+
+// ListBackups returns every saved backup, oldest first. Backups that can't
+// be read are skipped with a warning, like checkpoints.
+func ListBackups() ([]*Backup, error) {
+	paths, err := filepath.Glob(filepath.Join(backupDir(), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups: %v", err)
+	}
+	var backups []*Backup
+	for _, path := range paths {
+		backup, err := LoadBackup(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping backup %s: %v\n", path, err)
+			continue
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.Before(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// RestoreBackup puts the tracks of backup back into playlist on service in
+// their saved order. Tracks still there are kept, and the current contents
+// are backed up first so the restore can be undone. It is journaled like a
+// sync transfer, so an interrupted restore can be resumed.
+func RestoreBackup(service MusicService, playlist string, backup *Backup) error {
+	fmt.Printf("Restoring %d tracks of %s from %s\n", len(backup.Tracks), backup.Title, backup.CreatedAt.Local().Format(time.DateTime))
+	cp, err := backup.checkpoint(service, playlist, modeSync)
+	if err != nil {
+		return err
+	}
+	return cp.sync(service)
+}
+
+// RestoreBackupToNew creates a playlist named after the backup and adds its
+// tracks. Tracks the service refuses are skipped, and an interrupted
+// restore can be resumed.
+func RestoreBackupToNew(ctx context.Context, service MusicService, backup *Backup) (string, error) {
+	tracks := backup.restorable()
+	if err := CheckQuota(service, "restoring the playlist", QuotaEstimate{quotaInsert: len(tracks)}); err != nil {
+		return "", err
+	}
+	playlist, err := service.CreatePlaylist(backup.Title, "Restored by Playlistty", false)
+	if err != nil {
+		return "", err
+	}
+	cp, err := backup.checkpoint(service, playlist, modeReplace)
+	if err != nil {
+		return playlist, err
+	}
+	cp.Cleared = true
+	return playlist, cp.Run(ctx, service)
+}
+
+// checkpoint starts the journal for restoring backup into playlist.
+func (b *Backup) checkpoint(service MusicService, playlist string, mode string) (*Checkpoint, error) {
+	return startCheckpoint(service, &Checkpoint{
+		SourceService: "backup",
+		SourceID:      b.Name,
+		TargetID:      playlist,
+		Mode:          mode,
+		Tracks:        b.restorable(),
+	})
+}
+
+// restorable returns the tracks of the backup that can be added again.
+// Tracks without an ID, such as Spotify local files, can't be.
+func (b *Backup) restorable() []Track {
+	var tracks []Track
+	for _, track := range b.Tracks {
+		if track.ID != "" {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
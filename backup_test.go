@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupSameSecond(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	backupClock = &fakeClock{now: daemonStart}
+	t.Cleanup(func() { backupClock = realClock{} })
+
+	service := newFakeService("right")
+	service.set("dst", "abc")
+	first, err := BackupPlaylist(service, "dst", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.set("dst", "de")
+	second, err := BackupPlaylist(service, "dst", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Name == second.Name {
+		t.Fatalf("both backups are named %s", first.Name)
+	}
+	for _, backup := range []*Backup{first, second} {
+		if _, err := os.Stat(filepath.Join(backupDir(), backup.Name+".json")); err != nil {
+			t.Errorf("backup %s lost: %v", backup.Name, err)
+		}
+	}
+	backups, err := ListBackups()
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("ListBackups() = %d backups, %v, want 2", len(backups), err)
+	}
+
+	// Restoring the first one by name brings back its tracks, not the second's
+	backup, err := LoadBackup(first.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreBackup(service, "dst", backup); err != nil {
+		t.Fatal(err)
+	}
+	if songs := service.songs("dst"); songs != "abc" {
+		t.Errorf("restored playlist is %q, want %q", songs, "abc")
+	}
+}
+
+func TestListBackupsSkipsBroken(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	service := newFakeService("right")
+	service.set("dst", "abc")
+	backup, err := BackupPlaylist(service, "dst", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(backupDir(), "broken.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ListBackups()
+	if err != nil {
+		t.Fatalf("ListBackups() = %v, want the broken one skipped", err)
+	}
+	if len(backups) != 1 || backups[0].Name != backup.Name {
+		t.Errorf("ListBackups() = %d backups, want only %s", len(backups), backup.Name)
+	}
+
+	// Taking another backup isn't held up by it either
+	if _, err := BackupPlaylist(service, "dst", "test"); err != nil {
+		t.Errorf("BackupPlaylist() = %v with a broken backup around", err)
+	}
+}
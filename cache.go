@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultCacheTTL = 30 * 24 * time.Hour
+
+// CachedMatch is a resolved target track for one source track. Manual
+// entries are overrides set by the user and never expire.
+type CachedMatch struct {
+	TargetID   string    `json:"target_id"`
+	Score      float64   `json:"score"`
+	Manual     bool      `json:"manual,omitempty"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// MatchCache remembers matches across runs so songs resolved before don't
+// cost another search.
+type MatchCache struct {
+	Entries map[string]CachedMatch `json:"entries"`
+
+	ttl   time.Duration
+	path  string
+	dirty bool
+	mu    sync.Mutex
+}
+
+func matchCachePath() string {
+	return filepath.Join(CacheDir(), "matches.json")
+}
+
+// matchCacheKey identifies a source track by its ID, or by its normalized
+// name and artist when it has none.
+func matchCacheKey(sourceService string, track Track, targetService string) string {
+	if track.ID != "" {
+		return fmt.Sprintf("%s|id:%s|%s", sourceService, track.ID, targetService)
+	}
+	return fmt.Sprintf("%s|name:%s - %s|%s", sourceService, normalize(track.Name), normalize(track.Artist()), targetService)
+}
+
+// LoadMatchCache reads the match cache. A ttl of zero uses the default.
+func LoadMatchCache(ttl time.Duration) (*MatchCache, error) {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	cache := &MatchCache{Entries: map[string]CachedMatch{}, ttl: ttl, path: matchCachePath()}
+	data, err := os.ReadFile(cache.path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading match cache: %v", err)
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("error parsing match cache: %v", err)
+	}
+	if cache.Entries == nil {
+		cache.Entries = map[string]CachedMatch{}
+	}
+	return cache, nil
+}
+
+// Get returns the cached match for track unless it has expired.
+func (c *MatchCache) Get(sourceService string, track Track, targetService string) (CachedMatch, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.Entries[matchCacheKey(sourceService, track, targetService)]
+	if !ok || c.expired(entry, time.Now()) {
+		return CachedMatch{}, false
+	}
+	return entry, true
+}
+
+func (c *MatchCache) Put(sourceService string, track Track, targetService string, entry CachedMatch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.ResolvedAt.IsZero() {
+		entry.ResolvedAt = time.Now()
+	}
+	c.Entries[matchCacheKey(sourceService, track, targetService)] = entry
+	c.dirty = true
+}
+
+func (c *MatchCache) expired(entry CachedMatch, now time.Time) bool {
+	return !entry.Manual && now.Sub(entry.ResolvedAt) > c.ttl
+}
+
+// Prune drops expired entries and returns how many were removed.
+func (c *MatchCache) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	removed := 0
+	for key, entry := range c.Entries {
+		if c.expired(entry, now) {
+			delete(c.Entries, key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	return removed
+}
+
+// Clear drops every entry, keeping manual overrides unless all is set.
+func (c *MatchCache) Clear(all bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, entry := range c.Entries {
+		if all || !entry.Manual {
+			delete(c.Entries, key)
+			removed++
+		}
+	}
+	c.dirty = true
+	return removed
+}
+
+// Save writes the cache if it changed since it was loaded.
+func (c *MatchCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("error creating cache directory: %v", err)
+	}
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling match cache: %v", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("error writing match cache: %v", err)
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMatchCache(t *testing.T) {
+	// Ages of the cached matches, against a TTL of two days
+	entries := []struct {
+		id     string
+		age    time.Duration
+		manual bool
+	}{
+		{"fresh", time.Hour, false},
+		{"old", 3 * 24 * time.Hour, false},
+		{"manual", 30 * 24 * time.Hour, true},
+	}
+	tests := []struct {
+		name  string
+		apply func(cache *MatchCache) int
+		// Removed by apply, then left in the cache and found by Get
+		removed int
+		left    []string
+		found   []string
+	}{
+		{"get", func(cache *MatchCache) int { return 0 }, 0, []string{"fresh", "manual", "old"}, []string{"fresh", "manual"}},
+		{"prune", (*MatchCache).Prune, 1, []string{"fresh", "manual"}, []string{"fresh", "manual"}},
+		{"clear", func(cache *MatchCache) int { return cache.Clear(false) }, 2, []string{"manual"}, []string{"manual"}},
+		{"clear all", func(cache *MatchCache) int { return cache.Clear(true) }, 3, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+			cache, err := LoadMatchCache(48 * time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				cache.Put("spotify", Track{ID: entry.id}, "youtube", CachedMatch{
+					TargetID:   "yt-" + entry.id,
+					Score:      0.9,
+					Manual:     entry.manual,
+					ResolvedAt: time.Now().Add(-entry.age),
+				})
+			}
+			if removed := test.apply(cache); removed != test.removed {
+				t.Errorf("removed %d entries, want %d", removed, test.removed)
+			}
+
+			// What is left survives a reload
+			if err := cache.Save(); err != nil {
+				t.Fatal(err)
+			}
+			cache, err = LoadMatchCache(48 * time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var left, found []string
+			for _, entry := range entries {
+				if _, ok := cache.Entries[matchCacheKey("spotify", Track{ID: entry.id}, "youtube")]; ok {
+					left = append(left, entry.id)
+				}
+				if match, ok := cache.Get("spotify", Track{ID: entry.id}, "youtube"); ok {
+					found = append(found, entry.id)
+					if match.TargetID != "yt-"+entry.id {
+						t.Errorf("%s matched %s", entry.id, match.TargetID)
+					}
+				}
+			}
+			slices.Sort(left)
+			if !slices.Equal(left, test.left) || !slices.Equal(found, test.found) {
+				t.Errorf("cache has %v and finds %v, want %v and %v", left, found, test.left, test.found)
+			}
+		})
+	}
+}
+
+func TestMatchCacheCorrupt(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if err := os.MkdirAll(filepath.Dir(matchCachePath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(matchCachePath(), []byte(`{"entries": {`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cache, err := LoadMatchCache(0); err == nil {
+		t.Fatalf("LoadMatchCache() = %d entries, want an error", len(cache.Entries))
+	}
+}
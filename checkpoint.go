@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Checkpoint is the journal of a transfer writing to a target playlist.
+// It is saved before and after every batch so an interrupted transfer can
+// be resumed without clearing the target again or adding tracks twice.
+type Checkpoint struct {
+	ID            string `json:"id"`
+	SourceService string `json:"source_service"`
+	SourceID      string `json:"source_id"`
+	TargetService string `json:"target_service"`
+	TargetID      string `json:"target_id"`
+	Mode          string `json:"mode"`
+
+	// Cached playlist file the matches came from, the report goes next to
+	// it. Empty for restores, which have no report.
+	File string `json:"file"`
+
+	// Matched target tracks in the order they are added
+	Tracks []Track `json:"tracks"`
+
+	// Replace mode backs up and clears the target once before adding
+	Backup  string `json:"backup,omitempty"`
+	Cleared bool   `json:"cleared"`
+
+	// Tracks before Next are done, the next batch goes in at Position
+	Next     int `json:"next"`
+	Position int `json:"position"`
+
+	// Tracks sent from Next that the service has not confirmed, checked
+	// against the target on resume
+	Pending int `json:"pending,omitempty"`
+
+	Failed    []FailedTrack `json:"failed,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// FailedTrack is a track the target refused, by index into Tracks.
+type FailedTrack struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+func checkpointDir() string {
+	return filepath.Join(DataDir(), "transfers")
+}
+
+func checkpointPath(id string) string {
+	return filepath.Join(checkpointDir(), id+".json")
+}
+
+// NewCheckpoint starts the journal for writing the matched tracks of file to
+// playlist on target. Older checkpoints for the same playlist are dropped,
+// the new transfer replaces whatever they left.
+func NewCheckpoint(target MusicService, playlist string, file string, mode string) (*Checkpoint, error) {
+	source, err := LoadPlaylistFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return startCheckpoint(target, &Checkpoint{
+		SourceService: source.Service,
+		SourceID:      source.ID,
+		TargetID:      playlist,
+		Mode:          mode,
+		File:          file,
+		Tracks:        source.TargetTracks(),
+	})
+}
+
+// startCheckpoint saves cp as a new transfer to target.
+func startCheckpoint(target MusicService, cp *Checkpoint) (*Checkpoint, error) {
+	now := time.Now()
+	cp.ID = fmt.Sprintf("%s-%s", now.Format("20060102-150405"), cp.TargetID)
+	cp.TargetService = target.Name()
+	cp.StartedAt = now
+
+	checkpoints, err := ListCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	for _, old := range checkpoints {
+		if old.TargetService == cp.TargetService && old.TargetID == cp.TargetID {
+			if err := old.Remove(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cp, cp.Save()
+}
+
+func LoadCheckpoint(id string) (*Checkpoint, error) {
+	data, err := os.ReadFile(checkpointPath(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no unfinished transfer %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %v", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint: %v", err)
+	}
+	return &cp, nil
+}
+
+// ListCheckpoints returns the unfinished transfers, oldest first. Checkpoints
+// that can't be read are skipped with a warning.
+func ListCheckpoints() ([]*Checkpoint, error) {
+	paths, err := filepath.Glob(filepath.Join(checkpointDir(), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing checkpoints: %v", err)
+	}
+	var checkpoints []*Checkpoint
+	for _, path := range paths {
+		cp, err := LoadCheckpoint(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			// One broken file shouldn't hide the other transfers
+			fmt.Fprintf(os.Stderr, "Warning: skipping checkpoint %s: %v\n", path, err)
+			continue
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].StartedAt.Before(checkpoints[j].StartedAt)
+	})
+	return checkpoints, nil
+}
+
+func (cp *Checkpoint) Save() error {
+	cp.UpdatedAt = time.Now()
+	if err := os.MkdirAll(checkpointDir(), 0755); err != nil {
+		return fmt.Errorf("error creating checkpoint directory: %v", err)
+	}
+	data, err := json.MarshalIndent(cp, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling checkpoint: %v", err)
+	}
+	if err := os.WriteFile(checkpointPath(cp.ID), data, 0644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+	return nil
+}
+
+func (cp *Checkpoint) Remove() error {
+	if err := os.Remove(checkpointPath(cp.ID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing checkpoint: %v", err)
+	}
+	return nil
+}
+
+func (cp *Checkpoint) String() string {
+	return fmt.Sprintf("%s:%s -> %s:%s (%s, %d of %d tracks done)",
+		cp.SourceService, cp.SourceID, cp.TargetService, cp.TargetID, cp.Mode, cp.Next, len(cp.Tracks))
+}
+
+// stopped wraps the error that interrupted the transfer with how to go on.
+func (cp *Checkpoint) stopped(err error) error {
+	if cp.Mode == modeSync {
+		return fmt.Errorf("sync stopped, run 'playlistty resume %s' to continue: %w", cp.ID, err)
+	}
+	return fmt.Errorf("transfer stopped after %d of %d tracks, run 'playlistty resume %s' to continue: %w", cp.Next, len(cp.Tracks), cp.ID, err)
+}
+
+// Run adds the tracks that are left one batch at a time, saving progress
+// after each. When done it writes the transfer report and removes the
+// checkpoint. Tracks the target refuses are recorded and skipped, other
+// errors stop the transfer with the checkpoint kept for resume.
+func (cp *Checkpoint) Run(ctx context.Context, target MusicService) error {
+	var results []BatchResult
+
+	// A refused batch is sent again one track at a time up to here, so only
+	// the tracks at fault are skipped
+	single := 0
+
+	// Spotify refuses a bad playlist ID with the same message as a bad
+	// track ID, so the playlist is read once before tracks are blamed
+	checked := false
+	for cp.Next < len(cp.Tracks) {
+		if ctx.Err() != nil {
+			PrintBatchSummary(results)
+			return cp.stopped(ctx.Err())
+		}
+		size := target.BatchSize()
+		if cp.Next < single {
+			size = 1
+		}
+		end := min(cp.Next+size, len(cp.Tracks))
+		batch := cp.Tracks[cp.Next:end]
+
+		// Saved first, so a crash mid-request is checked on resume
+		cp.Pending = len(batch)
+		if err := cp.Save(); err != nil {
+			return err
+		}
+		batchResults := target.AddTracks(cp.TargetID, cp.Position, batch)
+
+		var err error
+		for _, result := range batchResults {
+			err = errors.Join(err, result.Err)
+		}
+		if refusedTrack(err) && !checked {
+			if _, readErr := target.ReadPlaylist(cp.TargetID); readErr != nil {
+				cp.Pending = 0
+				if saveErr := cp.Save(); saveErr != nil {
+					return saveErr
+				}
+				PrintBatchSummary(append(results, batchResults...))
+				return cp.stopped(fmt.Errorf("error reading target playlist: %v", readErr))
+			}
+			checked = true
+		}
+		switch {
+		case err == nil:
+			cp.Position += len(batch)
+		case refusedTrack(err) && len(batch) > 1:
+			fmt.Printf("Batch of %d tracks was refused, adding them one at a time\n", len(batch))
+			single = end
+			cp.Pending = 0
+			if err := cp.Save(); err != nil {
+				return err
+			}
+			continue
+		case refusedTrack(err):
+			for i := cp.Next; i < end; i++ {
+				cp.Failed = append(cp.Failed, FailedTrack{Index: i, Error: err.Error()})
+			}
+		case notApplied(err):
+			cp.Pending = 0
+			if saveErr := cp.Save(); saveErr != nil {
+				return saveErr
+			}
+			PrintBatchSummary(append(results, batchResults...))
+			return cp.stopped(err)
+		default:
+			// The tracks may or may not have been added, resume checks
+			if saveErr := cp.Save(); saveErr != nil {
+				return saveErr
+			}
+			PrintBatchSummary(append(results, batchResults...))
+			return cp.stopped(err)
+		}
+		results = append(results, batchResults...)
+		cp.Next, cp.Pending = end, 0
+		if err := cp.Save(); err != nil {
+			return err
+		}
+	}
+	PrintBatchSummary(results)
+	fmt.Println("Finished adding tracks to playlist")
+	return cp.finish(cp.failedResults())
+}
+
+// finish writes the report of a completed transfer and drops the checkpoint.
+func (cp *Checkpoint) finish(results []BatchResult) error {
+	cp.report(results)
+	if err := cp.Remove(); err != nil {
+		return err
+	}
+	if len(cp.Failed) > 0 {
+		return fmt.Errorf("%d of %d tracks could not be added", len(cp.Failed), len(cp.Tracks))
+	}
+	return nil
+}
+
+func (cp *Checkpoint) report(results []BatchResult) {
+	if cp.File != "" {
+		WriteTransferReport(cp.File, cp.TargetID, cp.Mode, results)
+	}
+}
+
+// failedResults turns the failed tracks of every run into batch results
+// for the report.
+func (cp *Checkpoint) failedResults() []BatchResult {
+	var results []BatchResult
+	for _, failed := range cp.Failed {
+		results = append(results, BatchResult{Position: -1, Tracks: cp.Tracks[failed.Index : failed.Index+1], Err: errors.New(failed.Error)})
+	}
+	return results
+}
+
+// backup saves the target before it is cleared, unless an earlier run of
+// the transfer already did.
+func (cp *Checkpoint) backup(target MusicService) error {
+	if cp.Backup != "" {
+		return nil
+	}
+	backup, err := BackupPlaylist(target, cp.TargetID, "replace")
+	if err != nil {
+		return err
+	}
+	cp.Backup = backup.Name
+	return cp.Save()
+}
+
+// sync brings the target in line with the checkpoint's tracks. Syncing
+// again only applies what is still missing, so this is also how sync
+// transfers resume.
+func (cp *Checkpoint) sync(target MusicService) error {
+	_, results, err := SyncTracks(target, cp.TargetID, cp.Tracks)
+	if results == nil && err != nil {
+		return cp.stopped(err)
+	}
+
+	// Tracks that could not be added are reported, not retried
+	cp.report(results)
+	if removeErr := cp.Remove(); removeErr != nil {
+		return removeErr
+	}
+	return err
+}
+
+// refusedTrack reports whether the service rejected the tracks themselves,
+// such as an unavailable video, so retrying them is pointless. Any other
+// refusal, like a missing or read-only playlist, stops the transfer.
+func refusedTrack(err error) bool {
+	return errors.Is(err, ErrBadTrack)
+}
+
+// notApplied reports whether err means the request was turned away before
+// it changed anything and can be sent again later.
+func notApplied(err error) bool {
+	if errors.Is(err, ErrQuota) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrAuth) {
+		return true
+	}
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+}
+
+// Resume continues an interrupted transfer. Sync transfers are synced again,
+// which only applies what is still missing.
+func (cp *Checkpoint) Resume(ctx context.Context, target MusicService) error {
+	fmt.Printf("Resuming transfer %s: %s\n", cp.ID, cp)
+	if cp.Mode == modeSync {
+		return cp.sync(target)
+	}
+
+	// Clearing again is safe until the first track was added
+	if !cp.Cleared {
+		if err := cp.backup(target); err != nil {
+			return err
+		}
+		if err := target.ClearPlaylist(cp.TargetID); err != nil {
+			return err
+		}
+		cp.Cleared = true
+		if err := cp.Save(); err != nil {
+			return err
+		}
+	}
+
+	// A batch that was sent when the transfer stopped may have landed
+	if cp.Pending > 0 {
+		current, err := target.ReadPlaylist(cp.TargetID)
+		if err != nil {
+			return err
+		}
+		if cp.pendingAdded(current.Tracks) {
+			fmt.Printf("The last batch of %d tracks was added before the transfer stopped\n", cp.Pending)
+			cp.Next += cp.Pending
+			cp.Position += cp.Pending
+		}
+		cp.Pending = 0
+		if err := cp.Save(); err != nil {
+			return err
+		}
+	}
+
+	estimate := QuotaEstimate{quotaInsert: len(cp.Tracks) - cp.Next}
+	if err := CheckQuota(target, "resuming the transfer", estimate); err != nil {
+		return err
+	}
+	return cp.Run(ctx, target)
+}
+
+// pendingAdded reports whether the pending batch is on the target at the
+// position it was sent to. Tracks are compared by playlist position, the
+// read can skip unavailable items.
+func (cp *Checkpoint) pendingAdded(current []Track) bool {
+	at := map[int]string{}
+	for _, track := range current {
+		at[track.Position] = track.ID
+	}
+	for i, track := range cp.Tracks[cp.Next : cp.Next+cp.Pending] {
+		if id, found := at[cp.Position+i]; !found || id != track.ID {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+// refusingService refuses songs as Spotify does, with the same error for a
+// bad track ID and a bad playlist ID.
+type refusingService struct {
+	*fakeService
+	refused string
+}
+
+func (r *refusingService) ReadPlaylist(playlist string) (*Playlist, error) {
+	if _, found := r.playlists[playlist]; !found {
+		return nil, &APIError{Service: r.name, StatusCode: 400, Status: "400 Bad Request", Message: "Invalid base62 id", Kind: ErrBadTrack}
+	}
+	return r.fakeService.ReadPlaylist(playlist)
+}
+
+func (r *refusingService) AddTracks(playlist string, position int, tracks []Track) []BatchResult {
+	refused := false
+	for _, track := range tracks {
+		refused = refused || strings.Contains(r.refused, strings.TrimPrefix(track.ID, r.name+"-"))
+	}
+	if _, found := r.playlists[playlist]; !found || refused {
+		err := &APIError{Service: r.name, StatusCode: 400, Status: "400 Bad Request", Message: "Invalid base62 id", Kind: ErrBadTrack}
+		return []BatchResult{{Position: position, Tracks: tracks, Err: err}}
+	}
+	return r.fakeService.AddTracks(playlist, position, tracks)
+}
+
+func newRefusingCheckpoint(t *testing.T, target *refusingService, playlist string, songs string) *Checkpoint {
+	t.Helper()
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	cp := &Checkpoint{ID: "test", TargetService: target.name, TargetID: playlist, Mode: modeReplace, Cleared: true}
+	for _, song := range strings.Split(songs, "") {
+		cp.Tracks = append(cp.Tracks, target.track(song))
+	}
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
+
+func TestRunSkipsRefusedTracks(t *testing.T) {
+	target := &refusingService{fakeService: newFakeService("right"), refused: "b"}
+	target.set("dst", "a")
+	cp := newRefusingCheckpoint(t, target, "dst", "bcd")
+
+	err := cp.Run(context.Background(), target)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 tracks") {
+		t.Fatalf("Run() = %v, want 1 of 3 tracks failed", err)
+	}
+	if songs := target.songs("dst"); songs != "acd" {
+		t.Errorf("playlist is %q, want %q", songs, "acd")
+	}
+	if len(cp.Failed) != 1 || cp.Failed[0].Index != 0 {
+		t.Errorf("failed tracks are %v, want only the first", cp.Failed)
+	}
+	if _, err := os.Stat(checkpointPath(cp.ID)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint kept after the transfer finished")
+	}
+}
+
+func TestRunStopsOnBadPlaylist(t *testing.T) {
+	target := &refusingService{fakeService: newFakeService("right")}
+	cp := newRefusingCheckpoint(t, target, "missing", "abc")
+
+	err := cp.Run(context.Background(), target)
+	if err == nil || !strings.Contains(err.Error(), "resume") {
+		t.Fatalf("Run() = %v, want the transfer stopped", err)
+	}
+	if len(cp.Failed) > 0 || cp.Next != 0 || cp.Pending != 0 {
+		t.Errorf("checkpoint has %d failed, next %d, pending %d, want nothing done", len(cp.Failed), cp.Next, cp.Pending)
+	}
+	if _, err := os.Stat(checkpointPath(cp.ID)); err != nil {
+		t.Errorf("checkpoint removed after the transfer stopped: %v", err)
+	}
+}
+
+func TestListCheckpointsSkipsBroken(t *testing.T) {
+	target := &refusingService{fakeService: newFakeService("right")}
+	cp := newRefusingCheckpoint(t, target, "dst", "abc")
+	if err := os.WriteFile(checkpointPath("broken"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints, err := ListCheckpoints()
+	if err != nil {
+		t.Fatalf("ListCheckpoints() = %v, want the broken one skipped", err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].ID != cp.ID {
+		t.Errorf("ListCheckpoints() = %v, want only %s", checkpoints, cp.ID)
+	}
+}
@@ -0,0 +1,1046 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes returned by subcommands
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+type Command struct {
+	Name    string
+	Args    string
+	Summary string
+	Run     func(args []string) int
+}
+
+var commands = map[string]*Command{}
+
+func RegisterCommand(cmd *Command) {
+	commands[cmd.Name] = cmd
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "interactive",
+		Args:    "[-config <path>] [-mode replace|sync] [-dry-run] [-plan <file>] <service>",
+		Summary: "Transfer a playlist using interactive prompts",
+		Run:     runInteractive,
+	})
+	RegisterCommand(&Command{
+		Name:    "auth",
+		Args:    "[-config <path>] <service>",
+		Summary: "Run the OAuth flow and store a token for a service",
+		Run:     runAuth,
+	})
+	RegisterCommand(&Command{
+		Name:    "list",
+		Args:    "[-config <path>] <service>",
+		Summary: "List your playlists on a service",
+		Run:     runList,
+	})
+	RegisterCommand(&Command{
+		Name:    "read",
+		Args:    "[-config <path>] <service>:<id>",
+		Summary: "Print a playlist's tracks and cache them",
+		Run:     runRead,
+	})
+	RegisterCommand(&Command{
+		Name:    "search",
+		Args:    "[-config <path>] [-isrc <code>] <service> <song> [artist]",
+		Summary: "Search a service for a song and score the candidates",
+		Run:     runSearch,
+	})
+	RegisterCommand(&Command{
+		Name:    "review",
+		Args:    "[-config <path>] <service>:<id>",
+		Summary: "Choose matches for unmatched and low confidence tracks of a read playlist",
+		Run:     runReview,
+	})
+	RegisterCommand(&Command{
+		Name:    "report",
+		Args:    "[-json] <service>:<id>",
+		Summary: "Print the report of the last transfer of a playlist",
+		Run:     runReport,
+	})
+	RegisterCommand(&Command{
+		Name:    "clear",
+		Args:    "[-config <path>] -yes <service>:<id>",
+		Summary: "Remove every track from a playlist",
+		Run:     runClear,
+	})
+	RegisterCommand(&Command{
+		Name:    "transfer",
+		Args:    "[-config <path>] -from <service>:<id> -to <service>:<id|new> [-name <name>] [-description <text>] [-public] [-mode replace|sync|twoway] [-conflicts <policy>] [-review] [-dry-run] [-plan <file>]",
+		Summary: "Copy a playlist to another playlist, replacing or syncing its contents",
+		Run:     runTransfer,
+	})
+	RegisterCommand(&Command{
+		Name:    "sync",
+		Args:    "[-config <path>] [-dry-run] [link-id...]",
+		Summary: "Transfer every linked playlist again using its link's mode",
+		Run:     runSync,
+	})
+	RegisterCommand(&Command{
+		Name:    "link",
+		Args:    "add [-config <path>] [-mode replace|sync|twoway] [-every <interval> | -cron <expr>] <service>:<id> <service>:<id> | list [-config <path>] | remove [-config <path>] <link-id>",
+		Summary: "Add, list or remove links between source and target playlists",
+		Run:     runLink,
+	})
+	RegisterCommand(&Command{
+		Name:    "daemon",
+		Args:    "[-config <path>] [-every <interval>]",
+		Summary: "Keep running and sync each link on its schedule",
+		Run:     runDaemon,
+	})
+	RegisterCommand(&Command{
+		Name:    "cache",
+		Args:    "prune [-config <path>] | clear [-config <path>] [-all] | set [-config <path>] <service>:<track-id> <service>:<track-id> | list [-config <path>]",
+		Summary: "Manage the cache of matched tracks",
+		Run:     runCache,
+	})
+	RegisterCommand(&Command{
+		Name:    "resume",
+		Args:    "[-config <path>] [transfer-id]",
+		Summary: "Continue an interrupted transfer, or list them",
+		Run:     runResume,
+	})
+	RegisterCommand(&Command{
+		Name:    "restore",
+		Args:    "[-config <path>] [-to <service>:<id|new>] [backup]",
+		Summary: "Put a playlist back the way a backup saved it, or list backups",
+		Run:     runRestore,
+	})
+	RegisterCommand(&Command{
+		Name:    "snapshot",
+		Args:    "[-config <path>] <service>:<id>",
+		Summary: "Save the current tracks of a playlist to its history",
+		Run:     runSnapshot,
+	})
+	RegisterCommand(&Command{
+		Name:    "history",
+		Args:    "[<service>:<id>]",
+		Summary: "List the saved snapshots of one or every playlist",
+		Run:     runHistory,
+	})
+	RegisterCommand(&Command{
+		Name:    "diff",
+		Args:    "<service>:<id>[@<time>] <service>:<id>[@<time>]",
+		Summary: "Show the tracks added, removed and moved between two snapshots",
+		Run:     runDiff,
+	})
+	RegisterCommand(&Command{
+		Name:    "quota",
+		Args:    "[-config <path>] [service]",
+		Summary: "Show today's API quota usage by call type",
+		Run:     runQuota,
+	})
+}
+
+// RunCommand dispatches to the named subcommand and returns its exit code.
+func RunCommand(name string, args []string) int {
+	if name == "help" {
+		printUsage()
+		return exitOK
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+		printUsage()
+		return exitUsage
+	}
+	return cmd.Run(args)
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: playlistty <command> [flags] [args]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].Summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'playlistty <command> -help' for details.\n")
+	fmt.Fprintf(os.Stderr, "Services: %s (yt is accepted for youtube)\n", strings.Join(ServiceNames(), ", "))
+}
+
+// newFlagSet returns a flag set for cmd with the shared -config flag.
+func newFlagSet(cmd string) (*flag.FlagSet, *string) {
+	fs := newLocalFlagSet(cmd)
+	configPath := fs.String("config", DefaultConfigPath(), "Path to config file")
+	return fs, configPath
+}
+
+// newLocalFlagSet returns a flag set for a cmd that only reads local files
+// and has no use for the config.
+func newLocalFlagSet(cmd string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: playlistty %s %s\n", cmd, commands[cmd].Args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses args into fs and checks the positional argument count.
+func parseArgs(fs *flag.FlagSet, args []string, min int, max int) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+// loadService returns the named service with a validated token.
+func loadService(name string, configPath string) (MusicService, error) {
+	if err := EnsureConfig(configPath); err != nil {
+		return nil, err
+	}
+	service, err := GetService(serviceName(name), configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.ValidateAuth(); err != nil {
+		return nil, fmt.Errorf("error validating token: %v", err)
+	}
+	return service, nil
+}
+
+// parseOptionalConfig reads the config at path for commands that work
+// without one. A missing file gives the defaults, a broken one an error.
+func parseOptionalConfig(path string) (*Config, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	return ParseConfig(path)
+}
+
+// interruptContext is cancelled by the first Ctrl-C or SIGTERM, after
+// which a second one kills the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	return exitError
+}
+
+func runInteractive(args []string) int {
+	fs, configPath := newFlagSet("interactive")
+	mode := fs.String("mode", modeReplace, "How to update an existing target: replace clears it first, sync applies only the differences")
+	dryRun := fs.Bool("dry-run", false, "Show the planned changes without modifying the target")
+	planFile := fs.String("plan", "", "With -dry-run, also write the plan as JSON to this file")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	service := serviceName(fs.Arg(0))
+	if _, ok := registry[service]; !ok {
+		return fail(fmt.Errorf("unsupported service: %s", fs.Arg(0)))
+	}
+	if !validMode(*mode) {
+		fmt.Fprintf(os.Stderr, "Error: invalid mode %q: must be %s or %s\n", *mode, modeReplace, modeSync)
+		return exitUsage
+	}
+	if _, err := Run(&Flags{Service: service, ConfigPath: *configPath, Mode: *mode, DryRun: *dryRun, PlanFile: *planFile}); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runAuth(args []string) int {
+	fs, configPath := newFlagSet("auth")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	if err := EnsureConfig(*configPath); err != nil {
+		return fail(err)
+	}
+	if _, err := GenerateOAuthToken(serviceName(fs.Arg(0)), *configPath); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runList(args []string) int {
+	fs, configPath := newFlagSet("list")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	service, err := loadService(fs.Arg(0), *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if err := ListPlaylists(service); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runRead(args []string) int {
+	fs, configPath := newFlagSet("read")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	name, playlist, err := ParsePlaylistRef(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	service, err := loadService(name, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := ReadPlaylist(service, playlist); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runSearch(args []string) int {
+	fs, configPath := newFlagSet("search")
+	isrc := fs.String("isrc", "", "ISRC of the recording, looked up before text search where supported")
+	if !parseArgs(fs, args, 2, 3) {
+		return exitUsage
+	}
+	service, err := loadService(fs.Arg(0), *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	matcher, err := LoadMatcher(service, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+
+	query := Track{Name: fs.Arg(1), Artists: splitArtists(fs.Arg(2)), ISRC: *isrc}
+	ranked, err := matcher.Rank(context.Background(), query)
+	if err != nil {
+		return fail(err)
+	}
+	if len(ranked) == 0 {
+		fmt.Fprintln(os.Stderr, "No results found")
+		return exitError
+	}
+
+	// Print results
+	fmt.Println("Search results:")
+	for _, candidate := range ranked {
+		fmt.Printf("%s %s by %s (ID: %s)\n", formatScore(candidate.Score), candidate.Track.Name, candidate.Track.Artist(), candidate.Track.ID)
+	}
+	return exitOK
+}
+
+func runReview(args []string) int {
+	fs, configPath := newFlagSet("review")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	name, playlist, err := ParsePlaylistRef(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	// Review the matches of the last transfer or dry run
+	file := playlistFilePath(name, playlist)
+	source, err := LoadPlaylistFile(file)
+	if err != nil {
+		return fail(err)
+	}
+	if source.TargetService == "" {
+		return fail(fmt.Errorf("%s has not been matched yet, run transfer -dry-run first", fs.Arg(0)))
+	}
+	target, err := loadService(source.TargetService, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	matcher, err := LoadMatcher(target, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if err := ReviewMatches(context.Background(), matcher, file, bufio.NewReader(os.Stdin)); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runReport(args []string) int {
+	fs := newLocalFlagSet("report")
+	asJSON := fs.Bool("json", false, "Print the JSON report instead of Markdown")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	name, playlist, err := ParsePlaylistRef(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	ext := ".md"
+	if *asJSON {
+		ext = ".json"
+	}
+	data, err := os.ReadFile(reportFilePath(playlistFilePath(name, playlist), ext))
+	if err != nil {
+		return fail(fmt.Errorf("error reading report: %v", err))
+	}
+	fmt.Print(string(data))
+	return exitOK
+}
+
+func runClear(args []string) int {
+	fs, configPath := newFlagSet("clear")
+	yes := fs.Bool("yes", false, "Confirm that the playlist should be cleared")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	name, playlist, err := ParsePlaylistRef(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if !*yes {
+		fmt.Fprintln(os.Stderr, "Refusing to clear playlist without -yes")
+		return exitUsage
+	}
+	service, err := loadService(name, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := BackupPlaylist(service, playlist, "clear"); err != nil {
+		return fail(err)
+	}
+	if err := service.ClearPlaylist(playlist); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runTransfer(args []string) int {
+	fs, configPath := newFlagSet("transfer")
+	from := fs.String("from", "", "Source playlist as service:id")
+	to := fs.String("to", "", "Target playlist as service:id, or service:new to create one")
+	name := fs.String("name", "", "Name for a new target playlist (defaults to the source name)")
+	description := fs.String("description", "Made with Playlistty", "Description for a new target playlist")
+	public := fs.Bool("public", false, "Make a new target playlist public")
+	mode := fs.String("mode", modeReplace, "How to update an existing target: replace clears it first, sync applies only the differences, twoway merges changes made on either side")
+	conflicts := fs.String("conflicts", "", "With -mode twoway, how to resolve conflicting edits: keep, remove, source or target (default from config, else keep)")
+	dryRun := fs.Bool("dry-run", false, "Read and match tracks, then show the planned changes without modifying the target")
+	planFile := fs.String("plan", "", "With -dry-run, also write the plan as JSON to this file")
+	review := fs.Bool("review", false, "After matching, choose matches for unmatched and low confidence tracks")
+	if !parseArgs(fs, args, 0, 0) {
+		return exitUsage
+	}
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	if !validMode(*mode) && *mode != modeTwoWay {
+		fmt.Fprintf(os.Stderr, "Error: invalid mode %q: must be %s, %s or %s\n", *mode, modeReplace, modeSync, modeTwoWay)
+		return exitUsage
+	}
+	if *conflicts != "" && !validConflictPolicy(*conflicts) {
+		fmt.Fprintf(os.Stderr, "Error: invalid conflict policy %q: must be keep, remove, source or target\n", *conflicts)
+		return exitUsage
+	}
+
+	hostName, sourceID, err := ParsePlaylistRef(*from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	targetName, targetID, err := ParsePlaylistRef(*to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if targetID == "new" {
+		targetID = ""
+	}
+
+	host, err := loadService(hostName, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	target := host
+	if targetName != hostName {
+		if target, err = loadService(targetName, *configPath); err != nil {
+			return fail(err)
+		}
+	}
+
+	matcher, err := LoadMatcher(target, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if *conflicts == "" {
+		config, err := ParseConfig(*configPath)
+		if err != nil {
+			return fail(err)
+		}
+		*conflicts = config.Sync.Conflicts
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	targetID, err = Transfer(ctx, TransferOptions{
+		Host:        host,
+		Matcher:     matcher,
+		SourceID:    sourceID,
+		TargetID:    targetID,
+		TargetName:  *name,
+		Description: *description,
+		Public:      *public,
+		Mode:        *mode,
+		Conflicts:   *conflicts,
+		Review:      *review,
+		DryRun:      *dryRun,
+		PlanFile:    *planFile,
+	})
+
+	// Remember the pair for sync, even if some tracks failed
+	if !*dryRun && targetID != "" {
+		link := Link{SourceService: host.Name(), SourceID: sourceID, TargetService: target.Name(), TargetID: targetID, Mode: *mode}
+		if linkErr := RecordLink(*configPath, link, err == nil); linkErr != nil {
+			fmt.Fprintf(os.Stderr, "Error saving link: %v\n", linkErr)
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runSync(args []string) int {
+	fs, configPath := newFlagSet("sync")
+	dryRun := fs.Bool("dry-run", false, "Show the planned changes for each link without modifying any target")
+	if !parseArgs(fs, args, 0, -1) {
+		return exitUsage
+	}
+	store, err := LoadLinks(*configPath)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Sync every link unless some are named
+	links := store.Links
+	if fs.NArg() > 0 {
+		links = nil
+		for _, arg := range fs.Args() {
+			id, err := strconv.Atoi(arg)
+			link := store.Get(id)
+			if err != nil || link == nil {
+				fmt.Fprintf(os.Stderr, "Error: unknown link %s\n", arg)
+				return exitUsage
+			}
+			links = append(links, *link)
+		}
+	}
+	if len(links) == 0 {
+		fmt.Println("No links to sync, add one with 'playlistty link add'")
+		return exitOK
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	failed := 0
+	for _, link := range links {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("\n== Link %d: %s (%s)\n", link.ID, link, link.Mode)
+		if err := SyncLink(ctx, link, *configPath, *dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "Error syncing link %d: %v\n", link.ID, err)
+			failed++
+			continue
+		}
+		// Saved per link, the store may have changed while syncing
+		if !*dryRun {
+			if err := MarkSynced(*configPath, link.ID, time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving link %d: %v\n", link.ID, err)
+			}
+		}
+	}
+
+	fmt.Printf("\nSynced %d of %d links\n", len(links)-failed, len(links))
+	if failed > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+func runLink(args []string) int {
+	fs, configPath := newFlagSet("link")
+	mode := fs.String("mode", modeSync, "How the target is updated on sync: replace, sync or twoway")
+	every := fs.String("every", "", "How often the daemon syncs the link, such as 30m or 6h")
+	cron := fs.String("cron", "", "When the daemon syncs the link, as a cron expression such as \"0 6 * * *\"")
+	if len(args) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	action, args := args[0], args[1:]
+
+	switch action {
+	case "add":
+		if !parseArgs(fs, args, 2, 2) {
+			return exitUsage
+		}
+		if !validMode(*mode) && *mode != modeTwoWay {
+			fmt.Fprintf(os.Stderr, "Error: invalid mode %q: must be %s, %s or %s\n", *mode, modeReplace, modeSync, modeTwoWay)
+			return exitUsage
+		}
+		sourceService, sourceID, err := ParsePlaylistRef(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		targetService, targetID, err := ParsePlaylistRef(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		for _, service := range []string{sourceService, targetService} {
+			if _, ok := registry[service]; !ok {
+				return fail(fmt.Errorf("unsupported service: %s", service))
+			}
+		}
+		if *every != "" || *cron != "" {
+			if _, err := ParseSchedule(*every, *cron); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return exitUsage
+			}
+		}
+
+		store, err := LoadLinks(*configPath)
+		if err != nil {
+			return fail(err)
+		}
+		link := store.Add(Link{SourceService: sourceService, SourceID: sourceID, TargetService: targetService, TargetID: targetID, Mode: *mode, Every: *every, Cron: *cron})
+		if err := store.Save(); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Linked %s (link %d)\n", link, link.ID)
+
+	case "list":
+		if !parseArgs(fs, args, 0, 0) {
+			return exitUsage
+		}
+		store, err := LoadLinks(*configPath)
+		if err != nil {
+			return fail(err)
+		}
+		for _, link := range store.Links {
+			lastSync := "never"
+			if !link.LastSync.IsZero() {
+				lastSync = link.LastSync.Local().Format(time.DateTime)
+			}
+			schedule := "daemon default"
+			if link.Cron != "" {
+				schedule = "cron " + link.Cron
+			} else if link.Every != "" {
+				schedule = "every " + link.Every
+			}
+			fmt.Printf("%d. %s (%s, %s, last sync: %s)\n", link.ID, link, link.Mode, schedule, lastSync)
+		}
+
+	case "remove":
+		if !parseArgs(fs, args, 1, 1) {
+			return exitUsage
+		}
+		id, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid link ID %q\n", fs.Arg(0))
+			return exitUsage
+		}
+		store, err := LoadLinks(*configPath)
+		if err != nil {
+			return fail(err)
+		}
+		if !store.Remove(id) {
+			return fail(fmt.Errorf("no link with ID %d", id))
+		}
+		if err := store.Save(); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Removed link %d\n", id)
+
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	return exitOK
+}
+
+func runDaemon(args []string) int {
+	fs, configPath := newFlagSet("daemon")
+	every := fs.String("every", "", "Schedule for links without their own, such as 30m (default from config, else 1h)")
+	if !parseArgs(fs, args, 0, 0) {
+		return exitUsage
+	}
+	if err := EnsureConfig(*configPath); err != nil {
+		return fail(err)
+	}
+	config, err := ParseConfig(*configPath)
+	if err != nil {
+		return fail(err)
+	}
+	if *every == "" {
+		*every = config.Daemon.Every
+	}
+	if *every != "" {
+		if _, err := ParseSchedule(*every, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	// Nobody is around to finish a browser login
+	interactiveAuth = false
+
+	// The first signal lets a write in progress finish, a second one quits
+	ctx, stop := interruptContext()
+	defer stop()
+
+	daemon := &Daemon{
+		Every: *every,
+		Links: func() ([]Link, error) {
+			store, err := LoadLinks(*configPath)
+			if err != nil {
+				return nil, err
+			}
+			return store.Links, nil
+		},
+		Sync: func(ctx context.Context, link Link) error {
+			if err := SyncLink(ctx, link, *configPath, false); err != nil {
+				return err
+			}
+			return MarkSynced(*configPath, link.ID, time.Now())
+		},
+	}
+	if err := daemon.Run(ctx); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runCache(args []string) int {
+	fs, configPath := newFlagSet("cache")
+	all := fs.Bool("all", false, "With clear, also remove manual overrides")
+	if len(args) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	action, args := args[0], args[1:]
+
+	// A missing config just means the default TTL
+	load := func() (*MatchCache, error) {
+		config, err := parseOptionalConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		return config.Matching.loadCache()
+	}
+
+	switch action {
+	case "prune":
+		if !parseArgs(fs, args, 0, 0) {
+			return exitUsage
+		}
+		cache, err := load()
+		if err != nil {
+			return fail(err)
+		}
+		removed := cache.Prune()
+		if err := cache.Save(); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Removed %d expired matches, %d left\n", removed, len(cache.Entries))
+
+	case "clear":
+		if !parseArgs(fs, args, 0, 0) {
+			return exitUsage
+		}
+		cache, err := load()
+		if err != nil {
+			return fail(err)
+		}
+		removed := cache.Clear(*all)
+		if err := cache.Save(); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Removed %d matches\n", removed)
+
+	case "set":
+		if !parseArgs(fs, args, 2, 2) {
+			return exitUsage
+		}
+		sourceService, sourceID, err := ParsePlaylistRef(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		targetService, targetID, err := ParsePlaylistRef(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		cache, err := load()
+		if err != nil {
+			return fail(err)
+		}
+		cache.Put(sourceService, Track{ID: sourceID}, targetService, CachedMatch{TargetID: targetID, Score: 1, Manual: true})
+		if err := cache.Save(); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("%s:%s will always match %s:%s\n", sourceService, sourceID, targetService, targetID)
+
+	case "list":
+		if !parseArgs(fs, args, 0, 0) {
+			return exitUsage
+		}
+		cache, err := load()
+		if err != nil {
+			return fail(err)
+		}
+		keys := make([]string, 0, len(cache.Entries))
+		for key := range cache.Entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := cache.Entries[key]
+			kind := formatScore(entry.Score)
+			if entry.Manual {
+				kind = "manual"
+			}
+			fmt.Printf("%s -> %s (%s, %s)\n", key, entry.TargetID, kind, entry.ResolvedAt.Local().Format(time.DateTime))
+		}
+
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	return exitOK
+}
+
+func runResume(args []string) int {
+	fs, configPath := newFlagSet("resume")
+	if !parseArgs(fs, args, 0, 1) {
+		return exitUsage
+	}
+
+	// Without an ID, show what can be resumed
+	if fs.NArg() == 0 {
+		checkpoints, err := ListCheckpoints()
+		if err != nil {
+			return fail(err)
+		}
+		if len(checkpoints) == 0 {
+			fmt.Println("No unfinished transfers")
+		}
+		for _, cp := range checkpoints {
+			fmt.Printf("%s  %s, stopped %s\n", cp.ID, cp, cp.UpdatedAt.Local().Format(time.DateTime))
+		}
+		return exitOK
+	}
+
+	cp, err := LoadCheckpoint(fs.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	target, err := loadService(cp.TargetService, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	if err := cp.Resume(ctx, target); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runRestore(args []string) int {
+	fs, configPath := newFlagSet("restore")
+	to := fs.String("to", "", "Playlist to restore into instead of the original, <service>:new creates one")
+	if !parseArgs(fs, args, 0, 1) {
+		return exitUsage
+	}
+
+	// Without a backup, show the ones there are
+	if fs.NArg() == 0 {
+		backups, err := ListBackups()
+		if err != nil {
+			return fail(err)
+		}
+		if len(backups) == 0 {
+			fmt.Println("No backups")
+		}
+		for _, backup := range backups {
+			fmt.Printf("%s  %s (%d tracks, before %s)\n", backup.Name, backup.Title, len(backup.Tracks), backup.Reason)
+		}
+		return exitOK
+	}
+
+	backup, err := LoadBackup(fs.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	name, playlist := backup.Service, backup.ID
+	if *to != "" {
+		if name, playlist, err = ParsePlaylistRef(*to); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		if name != backup.Service {
+			fmt.Fprintf(os.Stderr, "Error: backup is of a %s playlist, it can't be restored to %s\n", backup.Service, name)
+			return exitUsage
+		}
+	}
+	service, err := loadService(name, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+
+	if playlist == "new" {
+		ctx, stop := interruptContext()
+		defer stop()
+		created, err := RestoreBackupToNew(ctx, service, backup)
+		if created != "" {
+			fmt.Printf("Restored into new playlist %s:%s\n", service.Name(), created)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		return exitOK
+	}
+	if err := RestoreBackup(service, playlist, backup); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runSnapshot(args []string) int {
+	fs, configPath := newFlagSet("snapshot")
+	if !parseArgs(fs, args, 1, 1) {
+		return exitUsage
+	}
+	name, playlist, err := ParsePlaylistRef(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	service, err := loadService(name, *configPath)
+	if err != nil {
+		return fail(err)
+	}
+	snapshot, err := TakeSnapshot(service, playlist)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("Saved snapshot %s (%d tracks)\n", snapshot.Ref(), len(snapshot.Playlist.Tracks))
+	return exitOK
+}
+
+func runHistory(args []string) int {
+	fs := newLocalFlagSet("history")
+	if !parseArgs(fs, args, 0, 1) {
+		return exitUsage
+	}
+	var name, playlist string
+	if fs.NArg() == 1 {
+		var err error
+		if name, playlist, err = ParsePlaylistRef(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+	snapshots, err := ListSnapshots(name, playlist)
+	if err != nil {
+		return fail(err)
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("No snapshots, take one with 'playlistty snapshot <service>:<id>'")
+	}
+	for _, snapshot := range snapshots {
+		fmt.Printf("%s  %s (%d tracks)\n", snapshot.Ref(), snapshot.Playlist.Name, len(snapshot.Playlist.Tracks))
+	}
+	return exitOK
+}
+
+func runDiff(args []string) int {
+	fs := newLocalFlagSet("diff")
+	if !parseArgs(fs, args, 2, 2) {
+		return exitUsage
+	}
+	before, err := LoadSnapshot(fs.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	after, err := LoadSnapshot(fs.Arg(1))
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("%s (%d tracks) -> %s (%d tracks)\n", before.Ref(), len(before.Playlist.Tracks), after.Ref(), len(after.Playlist.Tracks))
+	DiffSnapshots(before.Playlist.Tracks, after.Playlist.Tracks).Print()
+	return exitOK
+}
+
+func runQuota(args []string) int {
+	fs, configPath := newFlagSet("quota")
+	if !parseArgs(fs, args, 0, 1) {
+		return exitUsage
+	}
+	services := ServiceNames()
+	if fs.NArg() == 1 {
+		services = []string{serviceName(fs.Arg(0))}
+	}
+
+	// A missing config just means the default budget
+	config, err := parseOptionalConfig(*configPath)
+	if err != nil {
+		return fail(err)
+	}
+
+	shown := 0
+	for _, service := range services {
+		ledger, err := LoadQuotaLedger(service, config.Quota)
+		if err != nil {
+			return fail(err)
+		}
+		if ledger == nil {
+			continue
+		}
+		PrintQuota(service, ledger)
+		shown++
+	}
+	if shown == 0 {
+		fmt.Printf("%s has no daily quota\n", strings.Join(services, ", "))
+	}
+	return exitOK
+}
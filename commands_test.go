@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInteractiveCommandExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"invalid mode", []string{"-mode", "merge", "spotify"}, exitUsage},
+		{"broken config", []string{"spotify"}, exitError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte("spotify: ["), 0644); err != nil {
+				t.Fatal(err)
+			}
+			args := append([]string{"-config", path}, test.args...)
+			if code := RunCommand("interactive", args); code != test.code {
+				t.Errorf("interactive %v exited with %d, want %d", test.args, code, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Defaults for the daemon section of the config
+const (
+	defaultDaemonEvery = "1h"
+	daemonPoll         = time.Minute
+	daemonBackoff      = time.Minute
+	daemonMaxBackoff   = time.Hour
+)
+
+type DaemonConfig struct {
+	Every string `yaml:"every"`
+}
+
+// Clock is the time source of the daemon, the API clients, the quota
+// ledgers, backups and snapshots, replaced by a fake one in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Daemon syncs every link on its own schedule until its context is
+// cancelled. Links and Sync are called for every run so links added or
+// removed while it runs are picked up.
+type Daemon struct {
+	Clock Clock
+	Links func() ([]Link, error)
+	Sync  func(ctx context.Context, link Link) error
+
+	// Schedule for links without one of their own
+	Every string
+
+	// Longest time between checks for new links
+	Poll time.Duration
+
+	state map[int]*linkState
+}
+
+type linkState struct {
+	spec     string
+	schedule Schedule
+	due      time.Time
+	failures int
+}
+
+// Run loops until ctx is cancelled. Sync gets the same context, so a sync
+// in progress stops matching but finishes its writes before Run returns.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.Clock == nil {
+		d.Clock = realClock{}
+	}
+	if d.Poll <= 0 {
+		d.Poll = daemonPoll
+	}
+	d.state = map[int]*linkState{}
+
+	fmt.Println("Daemon started")
+	for {
+		next := d.runDue(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+
+		wait := d.Poll
+		if !next.IsZero() {
+			if until := next.Sub(d.Clock.Now()); until < wait {
+				wait = until
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-d.Clock.After(wait):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	fmt.Println("Daemon stopped")
+	return nil
+}
+
+// runDue syncs every link that is due and returns the next time one is.
+func (d *Daemon) runDue(ctx context.Context) time.Time {
+	links, err := d.Links()
+	if err != nil {
+		fmt.Printf("Error loading links: %v\n", err)
+		return time.Time{}
+	}
+
+	var next time.Time
+	seen := map[int]bool{}
+	for _, link := range links {
+		seen[link.ID] = true
+		state, err := d.linkState(link)
+		if err != nil {
+			continue
+		}
+
+		now := d.Clock.Now()
+		if !now.Before(state.due) && ctx.Err() == nil {
+			fmt.Printf("\n== %s Link %d: %s (%s)\n", now.Format(time.DateTime), link.ID, link, link.Mode)
+			err := d.Sync(ctx, link)
+			now = d.Clock.Now()
+			scheduled := state.schedule.Next(now)
+			if err != nil {
+				state.failures++
+				state.due = now.Add(daemonBackoffFor(state.failures))
+				if !scheduled.IsZero() && scheduled.Before(state.due) {
+					state.due = scheduled
+				}
+				fmt.Printf("Error syncing link %d: %v (retrying at %s)\n", link.ID, err, state.due.Format(time.DateTime))
+			} else {
+				state.failures = 0
+				state.due = scheduled
+			}
+		}
+		if !state.due.IsZero() && (next.IsZero() || state.due.Before(next)) {
+			next = state.due
+		}
+	}
+
+	// Forget links that were removed
+	for id := range d.state {
+		if !seen[id] {
+			delete(d.state, id)
+		}
+	}
+	return next
+}
+
+// linkState returns the schedule state of link, starting it over when the
+// link's schedule changed.
+func (d *Daemon) linkState(link Link) (*linkState, error) {
+	every := link.Every
+	if every == "" {
+		every = d.Every
+	}
+	if every == "" {
+		every = defaultDaemonEvery
+	}
+	spec := every + "|" + link.Cron
+	if state, ok := d.state[link.ID]; ok && state.spec == spec {
+		if state.schedule == nil {
+			return nil, fmt.Errorf("invalid schedule")
+		}
+		return state, nil
+	}
+
+	// Invalid schedules are reported once, then skipped until fixed
+	schedule, err := ParseSchedule(every, link.Cron)
+	if err != nil {
+		fmt.Printf("Error scheduling link %d: %v\n", link.ID, err)
+		d.state[link.ID] = &linkState{spec: spec}
+		return nil, err
+	}
+
+	// Links synced before are due one period after their last sync
+	state := &linkState{spec: spec, schedule: schedule, due: d.Clock.Now()}
+	if !link.LastSync.IsZero() {
+		state.due = schedule.Next(link.LastSync)
+	}
+	d.state[link.ID] = state
+	return state, nil
+}
+
+// daemonBackoffFor doubles the wait after each consecutive failure.
+func daemonBackoffFor(failures int) time.Duration {
+	backoff := daemonBackoff
+	for i := 1; i < failures && backoff < daemonMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, daemonMaxBackoff)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock moves time forward whenever the daemon waits.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+type syncCall struct {
+	id int
+	at time.Time
+}
+
+// fakeDaemon runs a daemon over links until the clock passes until. Sync
+// fails while fail returns an error and records the last sync like
+// MarkSynced otherwise.
+type fakeDaemon struct {
+	t     *testing.T
+	clock *fakeClock
+	links []Link
+	calls []syncCall
+	fail  func(call syncCall) error
+
+	// Called before every run of due links, to edit links while running
+	before func(now time.Time)
+}
+
+var daemonStart = time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func newFakeDaemon(t *testing.T, links ...Link) *fakeDaemon {
+	return &fakeDaemon{t: t, clock: &fakeClock{now: daemonStart}, links: links}
+}
+
+func (f *fakeDaemon) run(until time.Time) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &Daemon{
+		Clock: f.clock,
+		Links: func() ([]Link, error) {
+			now := f.clock.Now()
+			if now.After(until) {
+				cancel()
+			}
+			if f.before != nil {
+				f.before(now)
+			}
+			return append([]Link(nil), f.links...), nil
+		},
+		Sync: func(ctx context.Context, link Link) error {
+			call := syncCall{id: link.ID, at: f.clock.Now()}
+			f.calls = append(f.calls, call)
+			if f.fail != nil {
+				if err := f.fail(call); err != nil {
+					return err
+				}
+			}
+			for i := range f.links {
+				if f.links[i].ID == link.ID {
+					f.links[i].LastSync = call.at
+				}
+			}
+			return nil
+		},
+		Every: "1h",
+	}
+	if err := d.Run(ctx); err != nil {
+		f.t.Fatalf("Run: %v", err)
+	}
+}
+
+// times returns the sync times of link as offsets from the start.
+func (f *fakeDaemon) times(id int) []time.Duration {
+	var times []time.Duration
+	for _, call := range f.calls {
+		if call.id == id {
+			times = append(times, call.at.Sub(daemonStart))
+		}
+	}
+	return times
+}
+
+func equalTimes(t *testing.T, name string, got []time.Duration, want ...time.Duration) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: synced at %v, want %v", name, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s: synced at %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDaemonDueTimes(t *testing.T) {
+	f := newFakeDaemon(t,
+		// Never synced, due straight away and then every 30 minutes
+		Link{ID: 1, Every: "30m"},
+		// Synced 10 minutes ago, due one period after that
+		Link{ID: 2, Every: "30m", LastSync: daemonStart.Add(-10 * time.Minute)},
+		// Daemon default of 1h
+		Link{ID: 3},
+		// Cron schedule
+		Link{ID: 4, Cron: "15 1 * * *"},
+	)
+	f.run(daemonStart.Add(90 * time.Minute))
+
+	equalTimes(t, "every 30m", f.times(1), 0, 30*time.Minute, 60*time.Minute, 90*time.Minute)
+	equalTimes(t, "synced before", f.times(2), 20*time.Minute, 50*time.Minute, 80*time.Minute)
+	equalTimes(t, "default", f.times(3), 0, time.Hour)
+	equalTimes(t, "cron", f.times(4), 0, 75*time.Minute)
+}
+
+func TestDaemonInvalidSchedule(t *testing.T) {
+	f := newFakeDaemon(t,
+		Link{ID: 1, Every: "soon"},
+		Link{ID: 2, Cron: "0 0 31 2 *"},
+	)
+	f.run(daemonStart.Add(3 * time.Hour))
+	if len(f.calls) != 0 {
+		t.Fatalf("links with invalid schedules were synced: %v", f.calls)
+	}
+}
+
+func TestDaemonBackoff(t *testing.T) {
+	f := newFakeDaemon(t, Link{ID: 1, Every: "6h"})
+	failures := 0
+	f.fail = func(call syncCall) error {
+		if failures < 4 {
+			failures++
+			return errors.New("service unavailable")
+		}
+		return nil
+	}
+	f.run(daemonStart.Add(7 * time.Hour))
+
+	// Retried after 1, 2, 4 and 8 minutes, then back on the schedule
+	equalTimes(t, "backoff", f.times(1), 0, time.Minute, 3*time.Minute, 7*time.Minute, 15*time.Minute, 6*time.Hour+15*time.Minute)
+}
+
+func TestDaemonBackoffCappedBySchedule(t *testing.T) {
+	f := newFakeDaemon(t, Link{ID: 1, Every: "2m"})
+	f.fail = func(call syncCall) error {
+		return errors.New("service unavailable")
+	}
+	f.run(daemonStart.Add(10 * time.Minute))
+
+	// The schedule comes round before the backoff would
+	equalTimes(t, "capped", f.times(1), 0, time.Minute, 3*time.Minute, 5*time.Minute, 7*time.Minute, 9*time.Minute)
+}
+
+func TestDaemonScheduleChange(t *testing.T) {
+	f := newFakeDaemon(t, Link{ID: 1, Every: "1h"})
+	f.before = func(now time.Time) {
+		switch now.Sub(daemonStart) {
+		case 10 * time.Minute:
+			f.links[0].Every = "15m"
+		case 40 * time.Minute:
+			// Removed links are forgotten, adding it back starts over
+			f.links = nil
+		case 50 * time.Minute:
+			f.links = []Link{{ID: 1, Every: "1h"}}
+		}
+	}
+	f.run(daemonStart.Add(time.Hour))
+
+	equalTimes(t, "changed", f.times(1), 0, 15*time.Minute, 30*time.Minute, 50*time.Minute)
+}
+
+func TestDaemonCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := &fakeClock{now: daemonStart}
+	var synced []int
+	d := &Daemon{
+		Clock: clock,
+		Links: func() ([]Link, error) {
+			return []Link{{ID: 1, Every: "1h"}, {ID: 2, Every: "1h"}}, nil
+		},
+		Sync: func(syncCtx context.Context, link Link) error {
+			synced = append(synced, link.ID)
+			// The sync sees the cancellation and the next link is skipped
+			cancel()
+			if syncCtx.Err() == nil {
+				t.Error("sync context not cancelled")
+			}
+			return syncCtx.Err()
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not stop")
+	}
+	if len(synced) != 1 || synced[0] != 1 {
+		t.Fatalf("synced links %v, want [1]", synced)
+	}
+}
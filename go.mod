@@ -3,7 +3,8 @@ module playlistty
 go 1.24.0
 
 require (
-	cloud.google.com/go/compute/metadata v0.3.0 // indirect
-	golang.org/x/oauth2 v0.27.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/oauth2 v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require cloud.google.com/go/compute/metadata v0.3.0 // indirect
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot file names, and the part of a snapshot reference after the @
+const snapshotTimeFormat = "20060102-150405"
+
+// snapshotClock times snapshots, tests stop it to take several in one second
+var snapshotClock Clock = realClock{}
+
+// Snapshot is a playlist as it was read at one point in time. Snapshots
+// are saved in the same format as cached playlists.
+type Snapshot struct {
+	Playlist *Playlist
+	TakenAt  time.Time
+	// Seq tells apart snapshots taken in the same second, 0 for the first
+	// and then 2, 3 and so on like the -N suffix of backups
+	Seq int
+}
+
+func historyDir() string {
+	return filepath.Join(DataDir(), "history")
+}
+
+func snapshotPath(service string, playlist string, at time.Time, seq int) string {
+	return filepath.Join(historyDir(), service, playlist, snapshotName(at, seq)+".json")
+}
+
+func snapshotName(at time.Time, seq int) string {
+	if seq == 0 {
+		return at.Format(snapshotTimeFormat)
+	}
+	return fmt.Sprintf("%s-%d", at.Format(snapshotTimeFormat), seq)
+}
+
+// parseSnapshotName reads the time and -N suffix of a snapshot name.
+func parseSnapshotName(name string) (time.Time, int, error) {
+	at, suffix := name, ""
+	if len(name) > len(snapshotTimeFormat) {
+		at, suffix = name[:len(snapshotTimeFormat)], name[len(snapshotTimeFormat):]
+	}
+	takenAt, err := time.ParseInLocation(snapshotTimeFormat, at, time.Local)
+	if err != nil || suffix == "" {
+		return takenAt, 0, err
+	}
+	seq, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+	if err != nil || !strings.HasPrefix(suffix, "-") || seq < 2 {
+		return time.Time{}, 0, fmt.Errorf("invalid snapshot suffix %q", suffix)
+	}
+	return takenAt, seq, nil
+}
+
+// Ref returns the reference that names the snapshot on the command line,
+// such as spotify:37i9dQZF1DXcBWIGoYBM5M@20250301-093000.
+func (s *Snapshot) Ref() string {
+	return fmt.Sprintf("%s:%s@%s", s.Playlist.Service, s.Playlist.ID, snapshotName(s.TakenAt, s.Seq))
+}
+
+// TakeSnapshot reads playlist from service and adds it to the history. The
+// cached playlist file is left alone, it may hold matches.
+func TakeSnapshot(service MusicService, playlist string) (*Snapshot, error) {
+	result, err := service.ReadPlaylist(playlist)
+	if err != nil {
+		return nil, err
+	}
+	result.Service = service.Name()
+	result.ID = playlist
+
+	// Times are kept to the second like the file names
+	snapshot := &Snapshot{Playlist: result, TakenAt: snapshotClock.Now().Truncate(time.Second)}
+
+	// Never overwrite an earlier snapshot taken in the same second
+	path := snapshotPath(service.Name(), playlist, snapshot.TakenAt, 0)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		snapshot.Seq = n
+		path = snapshotPath(service.Name(), playlist, snapshot.TakenAt, n)
+	}
+	if err := SavePlaylistFile(path, result); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots returns the snapshots of one playlist, or of every playlist
+// when service is empty, oldest first.
+func ListSnapshots(service string, playlist string) ([]*Snapshot, error) {
+	pattern := filepath.Join(historyDir(), "*", "*", "*.json")
+	if service != "" {
+		pattern = filepath.Join(historyDir(), service, playlist, "*.json")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %v", err)
+	}
+
+	var snapshots []*Snapshot
+	for _, path := range paths {
+		snapshot, err := loadSnapshotFile(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if !snapshots[i].TakenAt.Equal(snapshots[j].TakenAt) {
+			return snapshots[i].TakenAt.Before(snapshots[j].TakenAt)
+		}
+		return snapshots[i].Seq < snapshots[j].Seq
+	})
+	return snapshots, nil
+}
+
+func loadSnapshotFile(path string) (*Snapshot, error) {
+	playlist, err := LoadPlaylistFile(path)
+	if err != nil {
+		return nil, err
+	}
+	takenAt, seq, err := parseSnapshotName(strings.TrimSuffix(filepath.Base(path), ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot time of %s: %v", path, err)
+	}
+	// Identify the playlist by where it is stored
+	playlist.Service = filepath.Base(filepath.Dir(filepath.Dir(path)))
+	playlist.ID = filepath.Base(filepath.Dir(path))
+	return &Snapshot{Playlist: playlist, TakenAt: takenAt, Seq: seq}, nil
+}
+
+// LoadSnapshot finds a snapshot by reference: service:id@time for a given
+// snapshot, with -N for a later one taken in the same second, or service:id
+// for the latest one.
+func LoadSnapshot(ref string) (*Snapshot, error) {
+	playlistRef, at, hasTime := strings.Cut(ref, "@")
+	service, playlist, err := ParsePlaylistRef(playlistRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasTime {
+		takenAt, seq, err := parseSnapshotName(at)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot time %q: expected %s", at, snapshotTimeFormat)
+		}
+		snapshot, err := loadSnapshotFile(snapshotPath(service, playlist, takenAt, seq))
+		if err != nil {
+			return nil, fmt.Errorf("no snapshot %s", ref)
+		}
+		return snapshot, nil
+	}
+
+	snapshots, err := ListSnapshots(service, playlist)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no snapshots of %s:%s, take one with 'playlistty snapshot %s:%s'", service, playlist, service, playlist)
+	}
+	return snapshots[len(snapshots)-1], nil
+}
+
+// SnapshotDiff is what changed between two versions of a playlist.
+type SnapshotDiff struct {
+	Added   []SnapshotTrack
+	Removed []SnapshotTrack
+	Moved   []TrackMove
+}
+
+type SnapshotTrack struct {
+	Track    Track
+	Position int
+}
+
+// DiffSnapshots compares the tracks of two versions of a playlist by ID,
+// or by what identifies tracks without one. Every copy of a track counts,
+// so a duplicate that was added shows up. Tracks that are in both but out
+// of order with the rest are moved.
+func DiffSnapshots(before []Track, after []Track) SnapshotDiff {
+	diff := SnapshotDiff{Added: []SnapshotTrack{}, Removed: []SnapshotTrack{}, Moved: []TrackMove{}}
+
+	// Pair copies of the same track in order
+	queues := map[string][]int{}
+	for i, track := range before {
+		key := snapshotKey(track)
+		queues[key] = append(queues[key], i)
+	}
+	kept := []int{}
+	from := map[int]int{}
+	for i, track := range after {
+		key := snapshotKey(track)
+		queue := queues[key]
+		if len(queue) == 0 {
+			diff.Added = append(diff.Added, SnapshotTrack{Track: track, Position: i})
+			continue
+		}
+		queues[key] = queue[1:]
+		kept = append(kept, queue[0])
+		from[queue[0]] = i
+	}
+	paired := map[int]bool{}
+	for _, i := range kept {
+		paired[i] = true
+	}
+	for i, track := range before {
+		if !paired[i] {
+			diff.Removed = append(diff.Removed, SnapshotTrack{Track: track, Position: i})
+		}
+	}
+
+	// Tracks outside the longest run still in the old order were moved
+	inOrder := longestIncreasing(kept)
+	for _, i := range kept {
+		if !inOrder[i] {
+			diff.Moved = append(diff.Moved, TrackMove{Track: before[i], From: i, To: from[i]})
+		}
+	}
+	return diff
+}
+
+// snapshotKey identifies a track across snapshots. Tracks without an ID,
+// such as Spotify local files, go by their URI or else by what they are.
+func snapshotKey(track Track) string {
+	switch {
+	case track.ID != "":
+		return track.ID
+	case track.URI != "":
+		return track.URI
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d", track.Name, strings.Join(track.Artists, "\x00"), track.DurationMs)
+}
+
+func (d SnapshotDiff) Print() {
+	fmt.Printf("\nAdded (%d):\n", len(d.Added))
+	for _, added := range d.Added {
+		fmt.Printf("+ %d. %s by %s\n", added.Position+1, added.Track.Name, added.Track.Artist())
+	}
+	fmt.Printf("\nRemoved (%d):\n", len(d.Removed))
+	for _, removed := range d.Removed {
+		fmt.Printf("- %d. %s by %s\n", removed.Position+1, removed.Track.Name, removed.Track.Artist())
+	}
+	fmt.Printf("\nMoved (%d):\n", len(d.Moved))
+	for _, move := range d.Moved {
+		fmt.Printf("~ %s by %s (%d -> %d)\n", move.Track.Name, move.Track.Artist(), move.From+1, move.To+1)
+	}
+}
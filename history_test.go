@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// snapshotTracks turns letters into tracks. Lower case letters are IDs,
+// upper case ones local files with only a URI and digits tracks with
+// neither.
+func snapshotTracks(songs string) []Track {
+	var tracks []Track
+	for _, song := range strings.Split(songs, "") {
+		switch {
+		case song == "":
+		case strings.ToLower(song) != song:
+			tracks = append(tracks, Track{URI: "spotify:local:" + song, Name: song})
+		case song >= "0" && song <= "9":
+			tracks = append(tracks, Track{Name: song, Artists: []string{"Local"}})
+		default:
+			tracks = append(tracks, Track{ID: song, Name: song})
+		}
+	}
+	return tracks
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	tests := []struct {
+		before  string
+		after   string
+		added   string
+		removed string
+		moved   string
+	}{
+		{"abc", "abc", "", "", ""},
+		{"abc", "abdc", "d", "", ""},
+		{"abc", "ac", "", "b", ""},
+		{"abc", "cab", "", "", "c"},
+		{"abc", "bca", "", "", "a"},
+		{"abc", "abcb", "b", "", ""},
+		{"abcb", "abc", "", "b", ""},
+
+		// Tracks without an ID are told apart by URI or by name
+		{"aBC", "aBD", "D", "C", ""},
+		{"aBC", "aCB", "", "", "C"},
+		{"a12", "a13", "3", "2", ""},
+		{"a12", "a21", "", "", "2"},
+		{"B1", "B1", "", "", ""},
+	}
+
+	names := func(tracks []SnapshotTrack) string {
+		var result strings.Builder
+		for _, track := range tracks {
+			result.WriteString(track.Track.Name)
+		}
+		return result.String()
+	}
+	for _, test := range tests {
+		diff := DiffSnapshots(snapshotTracks(test.before), snapshotTracks(test.after))
+		var moved strings.Builder
+		for _, move := range diff.Moved {
+			moved.WriteString(move.Track.Name)
+		}
+		if added, removed := names(diff.Added), names(diff.Removed); added != test.added || removed != test.removed || moved.String() != test.moved {
+			t.Errorf("DiffSnapshots(%q, %q) adds %q, removes %q and moves %q, want %q, %q and %q",
+				test.before, test.after, added, removed, moved.String(), test.added, test.removed, test.moved)
+		}
+	}
+}
+
+func TestSnapshotsSameSecond(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	snapshotClock = &fakeClock{now: daemonStart}
+	t.Cleanup(func() { snapshotClock = realClock{} })
+
+	service := newFakeService("right")
+	var refs []string
+	for _, songs := range []string{"a", "ab", "abc"} {
+		service.set("dst", songs)
+		snapshot, err := TakeSnapshot(service, "dst")
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, snapshot.Ref())
+	}
+	if refs[1] != refs[0]+"-2" || refs[2] != refs[0]+"-3" {
+		t.Fatalf("snapshots are %v, want -2 and -3 after the first", refs)
+	}
+
+	snapshots, err := ListSnapshots("right", "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, snapshot := range snapshots {
+		listed = append(listed, snapshot.Ref())
+	}
+	if strings.Join(listed, " ") != strings.Join(refs, " ") {
+		t.Errorf("ListSnapshots() = %v, want %v", listed, refs)
+	}
+
+	// Each loads its own tracks, and the last one is the latest
+	for i, ref := range append(refs, "right:dst") {
+		snapshot, err := LoadSnapshot(ref)
+		if err != nil {
+			t.Fatalf("LoadSnapshot(%q): %v", ref, err)
+		}
+		if want := min(i, 2) + 1; len(snapshot.Playlist.Tracks) != want {
+			t.Errorf("LoadSnapshot(%q) has %d tracks, want %d", ref, len(snapshot.Playlist.Tracks), want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Link remembers that a source playlist is kept in step with a target
+// playlist, so it can be synced again later.
+type Link struct {
+	ID            int       `yaml:"id"`
+	SourceService string    `yaml:"source_service"`
+	SourceID      string    `yaml:"source_id"`
+	TargetService string    `yaml:"target_service"`
+	TargetID      string    `yaml:"target_id"`
+	Mode          string    `yaml:"mode"`
+	LastSync      time.Time `yaml:"last_sync,omitempty"`
+
+	// Daemon schedule, an interval like "30m" or a cron expression
+	Every string `yaml:"every,omitempty"`
+	Cron  string `yaml:"cron,omitempty"`
+}
+
+type LinkStore struct {
+	Links []Link `yaml:"links"`
+
+	path string
+}
+
+func (l Link) String() string {
+	return fmt.Sprintf("%s:%s -> %s:%s", l.SourceService, l.SourceID, l.TargetService, l.TargetID)
+}
+
+// linksFilePath keeps links next to the config file they were made with.
+func linksFilePath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "links.yml")
+}
+
+// LoadLinks reads the links stored next to the config file. A missing file
+// has no links.
+func LoadLinks(configPath string) (*LinkStore, error) {
+	store := &LinkStore{path: linksFilePath(configPath)}
+	data, err := os.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading links file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("error parsing links file: %v", err)
+	}
+	return store, nil
+}
+
+func (s *LinkStore) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("error creating links directory: %v", err)
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error marshaling links: %v", err)
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("error writing links file: %v", err)
+	}
+	return nil
+}
+
+// Find returns the link between two playlists, or nil.
+func (s *LinkStore) Find(sourceService string, sourceID string, targetService string, targetID string) *Link {
+	for i := range s.Links {
+		link := &s.Links[i]
+		if link.SourceService == sourceService && link.SourceID == sourceID && link.TargetService == targetService && link.TargetID == targetID {
+			return link
+		}
+	}
+	return nil
+}
+
+func (s *LinkStore) Get(id int) *Link {
+	for i := range s.Links {
+		if s.Links[i].ID == id {
+			return &s.Links[i]
+		}
+	}
+	return nil
+}
+
+// Add stores link with a new ID, or updates the mode of an existing link
+// between the same playlists. An existing schedule is kept unless link
+// sets one.
+func (s *LinkStore) Add(link Link) *Link {
+	if existing := s.Find(link.SourceService, link.SourceID, link.TargetService, link.TargetID); existing != nil {
+		existing.Mode = link.Mode
+		if link.Every != "" || link.Cron != "" {
+			existing.Every, existing.Cron = link.Every, link.Cron
+		}
+		return existing
+	}
+	for _, l := range s.Links {
+		if l.ID > link.ID {
+			link.ID = l.ID
+		}
+	}
+	link.ID++
+	s.Links = append(s.Links, link)
+	return &s.Links[len(s.Links)-1]
+}
+
+func (s *LinkStore) Remove(id int) bool {
+	for i, link := range s.Links {
+		if link.ID == id {
+			s.Links = append(s.Links[:i], s.Links[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// RecordLink saves link after a transfer. Only a successful transfer sets
+// its last sync, a failed one keeps the link so it can be synced again.
+func RecordLink(configPath string, link Link, synced bool) error {
+	store, err := LoadLinks(configPath)
+	if err != nil {
+		return err
+	}
+	saved := store.Add(link)
+	if synced {
+		saved.LastSync = time.Now()
+	}
+	if err := store.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Linked %s (link %d)\n", saved, saved.ID)
+	return nil
+}
+
+// MarkSynced sets the last sync time of a stored link.
+func MarkSynced(configPath string, id int, at time.Time) error {
+	store, err := LoadLinks(configPath)
+	if err != nil {
+		return err
+	}
+	link := store.Get(id)
+	if link == nil {
+		return nil
+	}
+	link.LastSync = at
+	return store.Save()
+}
+
+// SyncLink transfers the source of link to its target again using the
+// link's mode.
+func SyncLink(ctx context.Context, link Link, configPath string, dryRun bool) error {
+	host, err := loadService(link.SourceService, configPath)
+	if err != nil {
+		return err
+	}
+	target := host
+	if link.TargetService != link.SourceService {
+		if target, err = loadService(link.TargetService, configPath); err != nil {
+			return err
+		}
+	}
+
+	config, err := ParseConfig(configPath)
+	if err != nil {
+		return err
+	}
+	matcher, err := LoadMatcher(target, configPath)
+	if err != nil {
+		return err
+	}
+	_, err = Transfer(ctx, TransferOptions{
+		Host:      host,
+		Matcher:   matcher,
+		SourceID:  link.SourceID,
+		TargetID:  link.TargetID,
+		Mode:      link.Mode,
+		Conflicts: config.Sync.Conflicts,
+		DryRun:    dryRun,
+	})
+	return err
+}
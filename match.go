@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Defaults used when the matching section of the config is left empty
+const (
+	defaultMatchThreshold  = 0.6
+	defaultMatchCandidates = 5
+	defaultReviewBelow     = 0.8
+	defaultMatchWorkers    = 4
+)
+
+// Weights of each signal in the combined score. Signals that are unknown
+// for a pair (such as a missing duration) are left out and the remaining
+// weights are rescaled.
+const (
+	titleWeight    = 0.45
+	artistWeight   = 0.30
+	durationWeight = 0.15
+	albumWeight    = 0.10
+)
+
+// Versions that are almost never what the source meant unless it says so
+var unwantedVersions = []string{
+	"karaoke", "cover", "instrumental", "remix", "live", "acoustic",
+	"sped up", "slowed", "nightcore", "8d", "reverb", "tribute",
+}
+
+// Words YouTube uploads add to titles that say nothing about the song
+var titleNoise = map[string]bool{
+	"official": true, "video": true, "audio": true, "lyrics": true, "lyric": true,
+	"music": true, "mv": true, "hd": true, "hq": true, "4k": true, "visualizer": true,
+	"topic": true, "clip": true, "officiel": true,
+}
+
+type MatchingConfig struct {
+	Threshold   float64 `yaml:"threshold"`
+	Candidates  int     `yaml:"candidates"`
+	ReviewBelow float64 `yaml:"review_below"`
+	CacheTTL    string  `yaml:"cache_ttl"`
+	Workers     int     `yaml:"workers"`
+}
+
+type ScoredTrack struct {
+	Track  Track   `json:"track"`
+	Score  float64 `json:"score"`
+	Cached bool    `json:"-"`
+
+	// Every candidate of the search, best first, on the match it found
+	Ranked []ScoredTrack `json:"-"`
+}
+
+type Matcher struct {
+	Target     MusicService
+	Threshold  float64
+	Candidates int
+
+	// Matches scoring under this are offered for review
+	ReviewBelow float64
+
+	// Matches from earlier runs, nil to always search
+	Cache *MatchCache
+
+	// Tracks searched at the same time
+	Workers int
+}
+
+func NewMatcher(target MusicService, config MatchingConfig) *Matcher {
+	matcher := &Matcher{
+		Target:      target,
+		Threshold:   config.Threshold,
+		Candidates:  config.Candidates,
+		ReviewBelow: config.ReviewBelow,
+		Workers:     config.Workers,
+	}
+	if matcher.Threshold <= 0 {
+		matcher.Threshold = defaultMatchThreshold
+	}
+	if matcher.Candidates <= 0 {
+		matcher.Candidates = defaultMatchCandidates
+	}
+	if matcher.ReviewBelow <= 0 {
+		matcher.ReviewBelow = defaultReviewBelow
+	}
+	if matcher.Workers <= 0 {
+		matcher.Workers = defaultMatchWorkers
+	}
+	return matcher
+}
+
+// For returns a matcher with the same settings that searches target.
+func (m *Matcher) For(target MusicService) *Matcher {
+	return &Matcher{Target: target, Threshold: m.Threshold, Candidates: m.Candidates, ReviewBelow: m.ReviewBelow, Cache: m.Cache, Workers: m.Workers}
+}
+
+// LoadMatcher builds a matcher for target using the matching section of
+// the config file and the match cache.
+func LoadMatcher(target MusicService, configPath string) (*Matcher, error) {
+	config, err := ParseConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := config.Matching.loadCache()
+	if err != nil {
+		return nil, err
+	}
+	matcher := NewMatcher(target, config.Matching)
+	matcher.Cache = cache
+	return matcher, nil
+}
+
+// loadCache reads the match cache with the configured TTL.
+func (c MatchingConfig) loadCache() (*MatchCache, error) {
+	var ttl time.Duration
+	if c.CacheTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(c.CacheTTL); err != nil {
+			return nil, fmt.Errorf("error parsing matching cache_ttl: %v", err)
+		}
+	}
+	return LoadMatchCache(ttl)
+}
+
+// Rank searches the target service for track and returns the candidates
+// ordered from best to worst score.
+func (m *Matcher) Rank(ctx context.Context, track Track) ([]ScoredTrack, error) {
+	candidates, err := m.Target.SearchTracks(ctx, track, m.Candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]ScoredTrack, len(candidates))
+	for i, candidate := range candidates {
+		ranked[i] = ScoredTrack{Track: candidate, Score: ScoreMatch(track, candidate)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked, nil
+}
+
+// Match returns the best candidate for track. The boolean is false when no
+// candidate reaches the threshold, the best score is still returned so it
+// can be recorded.
+func (m *Matcher) Match(ctx context.Context, track Track) (ScoredTrack, bool, error) {
+	ranked, err := m.Rank(ctx, track)
+	if err != nil {
+		return ScoredTrack{}, false, err
+	}
+	if len(ranked) == 0 {
+		return ScoredTrack{}, false, nil
+	}
+	best := ranked[0]
+	best.Ranked = ranked
+	return best, best.Score >= m.Threshold, nil
+}
+
+// needsReview reports whether track was not matched or was matched below
+// the review threshold.
+func (m *Matcher) needsReview(track Track) bool {
+	return track.TargetID == "" || track.MatchScore < m.ReviewBelow
+}
+
+// Resolve returns the match for a track read from sourceService, looking it
+// up in the match cache before searching. New matches are added to the
+// cache, which the caller saves.
+func (m *Matcher) Resolve(ctx context.Context, sourceService string, track Track) (ScoredTrack, bool, error) {
+	targetService := m.Target.Name()
+	if m.Cache != nil {
+		if entry, ok := m.Cache.Get(sourceService, track, targetService); ok {
+			cached := track
+			cached.ID, cached.URI, cached.ItemID = entry.TargetID, "", ""
+			return ScoredTrack{Track: cached, Score: entry.Score, Cached: true}, true, nil
+		}
+	}
+
+	best, ok, err := m.Match(ctx, track)
+	if err == nil && ok && m.Cache != nil {
+		m.Cache.Put(sourceService, track, targetService, CachedMatch{TargetID: best.Track.ID, Score: best.Score})
+	}
+	return best, ok, err
+}
+
+// ScoreMatch rates how likely candidate is the same recording as source,
+// from 0 to 1.
+func ScoreMatch(source Track, candidate Track) float64 {
+	// Same ISRC means same recording
+	if source.ISRC != "" && strings.EqualFold(source.ISRC, candidate.ISRC) {
+		return 1
+	}
+
+	sourceArtists := make([]string, len(source.Artists))
+	for i, artist := range source.Artists {
+		sourceArtists[i] = normalize(artist)
+	}
+	candidateTitle := normalize(stripTitleDecorations(candidate.Name))
+	candidateArtist := normalize(candidate.Artist())
+
+	total, weights := 0.0, 0.0
+	add := func(score float64, weight float64) {
+		total += score * weight
+		weights += weight
+	}
+
+	// Title, ignoring upload noise and the artist name in the candidate title
+	sourceTitle := removeWords(normalize(stripTitleDecorations(source.Name)), sourceArtists)
+	add(titleSimilarity(sourceTitle, removeWords(candidateTitle, sourceArtists)), titleWeight)
+
+	// Artist, found either in the candidate's artists or its title
+	if len(sourceArtists) > 0 {
+		found := 0
+		for _, artist := range sourceArtists {
+			if artist != "" && (containsPhrase(candidateArtist, artist) || containsPhrase(candidateTitle, artist)) {
+				found++
+			}
+		}
+		add(float64(found)/float64(len(sourceArtists)), artistWeight)
+	}
+
+	// Duration, full marks within 3 seconds and none past 30
+	if source.DurationMs > 0 && candidate.DurationMs > 0 {
+		delta := source.DurationMs - candidate.DurationMs
+		if delta < 0 {
+			delta = -delta
+		}
+		score := 1 - float64(delta-3000)/27000
+		add(clamp(score), durationWeight)
+	}
+
+	// Album
+	if source.Album != "" && candidate.Album != "" {
+		add(titleSimilarity(normalize(stripTitleDecorations(source.Album)), normalize(stripTitleDecorations(candidate.Album))), albumWeight)
+	}
+
+	score := total / weights
+
+	// Penalize covers, karaoke and other versions the source didn't ask for
+	sourceRaw := " " + normalize(source.Name+" "+source.Album) + " "
+	candidateRaw := " " + normalize(candidate.Name+" "+candidate.Album) + " "
+	for _, version := range unwantedVersions {
+		if strings.Contains(candidateRaw, " "+version+" ") && !strings.Contains(sourceRaw, " "+version+" ") {
+			score -= 0.3
+			break
+		}
+	}
+	return clamp(score)
+}
+
+// stripTitleDecorations removes featured artist and remaster tags which
+// vary between services for the same recording.
+func stripTitleDecorations(title string) string {
+	var b strings.Builder
+	var group strings.Builder
+	depth := 0
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r == '(' || r == '[':
+			depth++
+			if depth == 1 {
+				group.Reset()
+				continue
+			}
+		case (r == ')' || r == ']') && depth > 0:
+			depth--
+			if depth == 0 {
+				if !isDecoration(group.String()) {
+					b.WriteString(" " + group.String() + " ")
+				}
+				continue
+			}
+		}
+		if depth > 0 {
+			group.WriteRune(r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	if depth > 0 {
+		b.WriteString(" " + group.String())
+	}
+	result := b.String()
+
+	// Cut unbracketed "feat. X" tails
+	for _, marker := range []string{" feat. ", " feat ", " ft. ", " ft ", " featuring "} {
+		if i := strings.Index(result+" ", marker); i > 0 {
+			result = result[:i]
+		}
+	}
+
+	// Drop "- Remastered 2011" style suffixes
+	if i := strings.LastIndex(result, " - "); i > 0 && isDecoration(result[i+3:]) {
+		result = result[:i]
+	}
+	return result
+}
+
+func isDecoration(text string) bool {
+	text = strings.TrimSpace(strings.ToLower(text))
+	for _, prefix := range []string{"feat", "ft.", "ft ", "featuring", "with "} {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	for _, word := range []string{"remaster", "mono", "stereo", "single version", "album version", "radio edit", "explicit", "clean"} {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize lowercases text and reduces it to space separated words.
+func normalize(text string) string {
+	text = strings.ToLower(strings.ReplaceAll(text, "&", " and "))
+	var b strings.Builder
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(r)
+		} else if r != '\'' && r != '’' {
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// removeWords drops artist names and title noise from text, leaving it
+// unchanged if nothing else would remain.
+func removeWords(text string, phrases []string) string {
+	padded := " " + text + " "
+	for _, phrase := range phrases {
+		if phrase != "" {
+			padded = strings.ReplaceAll(padded, " "+phrase+" ", " ")
+		}
+	}
+	var words []string
+	for _, word := range strings.Fields(padded) {
+		if !titleNoise[word] {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return text
+	}
+	return strings.Join(words, " ")
+}
+
+func containsPhrase(text string, phrase string) bool {
+	return strings.Contains(" "+text+" ", " "+phrase+" ")
+}
+
+// titleSimilarity combines edit distance, which tolerates typos, with word
+// overlap, which tolerates reordering.
+func titleSimilarity(a string, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	aWords, bWords := strings.Fields(a), strings.Fields(b)
+	bSet := make(map[string]bool, len(bWords))
+	for _, word := range bWords {
+		bSet[word] = true
+	}
+	common := 0
+	for _, word := range aWords {
+		if bSet[word] {
+			common++
+		}
+	}
+	union := len(aWords) + len(bWords) - common
+	overlap := float64(common) / float64(union)
+
+	longest := len([]rune(a))
+	if n := len([]rune(b)); n > longest {
+		longest = n
+	}
+	edit := 1 - float64(levenshtein(a, b))/float64(longest)
+
+	if overlap > edit {
+		return overlap
+	}
+	return edit
+}
+
+func levenshtein(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
+
+func clamp(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 1 {
+		return 1
+	}
+	return score
+}
+
+func formatScore(score float64) string {
+	return fmt.Sprintf("%.0f%%", score*100)
+}
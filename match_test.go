@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Creep", "creep"},
+		{"  Karma   Police  ", "karma police"},
+		{"Don't Stop Me Now", "dont stop me now"},
+		{"Don’t Stop Believin’", "dont stop believin"},
+		{"Simon & Garfunkel", "simon and garfunkel"},
+		{"AC/DC", "ac dc"},
+		{"Beyoncé", "beyoncé"},
+		{"Song 2", "song 2"},
+		{"!!!", ""},
+	}
+
+	for _, test := range tests {
+		if got := normalize(test.text); got != test.want {
+			t.Errorf("normalize(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestStripTitleDecorations(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Creep", "creep"},
+
+		// Featured artists
+		{"Uptown Funk (feat. Bruno Mars)", "uptown funk"},
+		{"Uptown Funk [feat. Bruno Mars]", "uptown funk"},
+		{"Uptown Funk feat. Bruno Mars", "uptown funk"},
+		{"This Is What You Came For ft. Rihanna", "this is what you came for"},
+		{"Stay (with Justin Bieber)", "stay"},
+
+		// Remasters and editions
+		{"Here Comes The Sun - Remastered 2009", "here comes the sun"},
+		{"Paint It, Black (2002 Remaster)", "paint it black"},
+		{"Wonderwall (Radio Edit)", "wonderwall"},
+		{"Help! - Mono", "help"},
+
+		// Versions are different recordings and stay
+		{"Creep - Live", "creep live"},
+		{"Creep (Live)", "creep live"},
+		{"Creep (Acoustic)", "creep acoustic"},
+		{"Blue (Da Ba Dee) (feat. Someone)", "blue da ba dee"},
+	}
+
+	for _, test := range tests {
+		if got := normalize(stripTitleDecorations(test.title)); got != test.want {
+			t.Errorf("stripTitleDecorations(%q) = %q, want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestScoreMatch(t *testing.T) {
+	radiohead := []string{"Radiohead"}
+	tests := []struct {
+		name      string
+		source    Track
+		candidate Track
+		min, max  float64
+	}{
+		{"exact", Track{Name: "Creep", Artists: radiohead, Album: "Pablo Honey", DurationMs: 238000}, Track{Name: "Creep", Artists: radiohead, Album: "Pablo Honey", DurationMs: 238000}, 1, 1},
+		{"same ISRC", Track{Name: "Creep", ISRC: "GBAYE9200070"}, Track{Name: "Something Else", ISRC: "gbaye9200070"}, 1, 1},
+		{"different song", Track{Name: "Creep", Artists: radiohead}, Track{Name: "Karma Police", Artists: radiohead}, 0, 0.55},
+
+		// Decorations that vary between services
+		{"featured artist", Track{Name: "Uptown Funk (feat. Bruno Mars)", Artists: []string{"Mark Ronson", "Bruno Mars"}}, Track{Name: "Uptown Funk", Artists: []string{"Mark Ronson", "Bruno Mars"}}, 1, 1},
+		{"remaster", Track{Name: "Creep", Artists: radiohead}, Track{Name: "Creep - Remastered 2009", Artists: radiohead}, 1, 1},
+		{"upload noise", Track{Name: "Creep", Artists: radiohead}, Track{Name: "Radiohead - Creep (Official Music Video)", Artists: radiohead}, 1, 1},
+		{"live on both", Track{Name: "Creep (Live)", Artists: radiohead}, Track{Name: "Creep - Live", Artists: radiohead}, 1, 1},
+
+		// Artists in another order, or some of them missing
+		{"artist order", Track{Name: "Uptown Funk", Artists: []string{"Mark Ronson", "Bruno Mars"}}, Track{Name: "Uptown Funk", Artists: []string{"Bruno Mars", "Mark Ronson"}}, 1, 1},
+		{"missing artist", Track{Name: "Uptown Funk", Artists: []string{"Mark Ronson", "Bruno Mars"}}, Track{Name: "Uptown Funk", Artists: []string{"Mark Ronson"}}, 0.75, 0.85},
+
+		// Durations count fully within 3 seconds and not at all past 30
+		{"duration within 3s", Track{Name: "Creep", Artists: radiohead, DurationMs: 238000}, Track{Name: "Creep", Artists: radiohead, DurationMs: 241000}, 1, 1},
+		{"duration 15s off", Track{Name: "Creep", Artists: radiohead, DurationMs: 238000}, Track{Name: "Creep", Artists: radiohead, DurationMs: 253000}, 0.9, 0.95},
+		{"duration 30s off", Track{Name: "Creep", Artists: radiohead, DurationMs: 238000}, Track{Name: "Creep", Artists: radiohead, DurationMs: 268000}, 0.8, 0.85},
+		{"duration unknown", Track{Name: "Creep", Artists: radiohead, DurationMs: 238000}, Track{Name: "Creep", Artists: radiohead}, 1, 1},
+
+		// Versions the source didn't ask for
+		{"live", Track{Name: "Creep", Artists: radiohead}, Track{Name: "Creep - Live", Artists: radiohead}, 0, 0.5},
+		{"live in brackets", Track{Name: "Creep", Artists: radiohead}, Track{Name: "Creep (Live)", Artists: radiohead}, 0, 0.5},
+		{"karaoke", Track{Name: "Creep", Artists: radiohead}, Track{Name: "Creep (Karaoke Version)", Artists: radiohead}, 0, 0.5},
+		{"cover", Track{Name: "Creep", Artists: radiohead}, Track{Name: "Creep", Artists: []string{"Scala & Kolacny Brothers"}, Album: "Creep (Cover)"}, 0, 0.35},
+	}
+
+	for _, test := range tests {
+		score := ScoreMatch(test.source, test.candidate)
+		if score < test.min-1e-9 || score > test.max+1e-9 {
+			t.Errorf("%s: ScoreMatch(%q, %q) = %.3f, want %.2f to %.2f", test.name, test.source.Name, test.candidate.Name, score, test.min, test.max)
+		}
+	}
+}
+
+// searchService returns the same search results for every track.
+type searchService struct {
+	*fakeService
+	results []Track
+}
+
+func (s *searchService) SearchTracks(ctx context.Context, track Track, limit int) ([]Track, error) {
+	return s.results, nil
+}
+
+func TestMatchThreshold(t *testing.T) {
+	source := Track{Name: "Creep", Artists: []string{"Radiohead"}}
+
+	// The right title by another artist scores the default threshold
+	sameTitle := Track{ID: "1", Name: "Creep", Artists: []string{"Someone"}}
+	tests := []struct {
+		name      string
+		threshold float64
+		results   []Track
+		matched   bool
+	}{
+		{"at threshold", 0, []Track{sameTitle}, true},
+		{"under threshold", 0.61, []Track{sameTitle}, false},
+		{"no results", 0, nil, false},
+		{"best of several", 0.9, []Track{sameTitle, {ID: "2", Name: "Creep", Artists: []string{"Radiohead"}}}, true},
+	}
+
+	for _, test := range tests {
+		service := &searchService{fakeService: newFakeService("right"), results: test.results}
+		matcher := NewMatcher(service, MatchingConfig{Threshold: test.threshold})
+		best, matched, err := matcher.Match(context.Background(), source)
+		if err != nil {
+			t.Fatalf("%s: Match: %v", test.name, err)
+		}
+		if matched != test.matched {
+			t.Errorf("%s: Match found %q at %.3f, matched %v, want %v", test.name, best.Track.ID, best.Score, matched, test.matched)
+		}
+	}
+}
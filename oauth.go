@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const oauthRedirectURL = "http://localhost:3000/callback"
+
+// OAuthToken is the persisted form of an oauth2.Token. The access token keeps
+// the original "token" key so existing config files still load.
+type OAuthToken struct {
+	AccessToken  string    `yaml:"token"`
+	RefreshToken string    `yaml:"refresh_token,omitempty"`
+	TokenType    string    `yaml:"token_type,omitempty"`
+	Expiry       time.Time `yaml:"expiry,omitempty"`
+}
+
+// OAuthProvider returns the OAuth2 settings for a service along with the
+// slot in config its token is stored in.
+type OAuthProvider func(config *Config) (*oauth2.Config, *OAuthToken)
+
+var oauthProviders = map[string]OAuthProvider{}
+
+func RegisterOAuth(name string, provider OAuthProvider) {
+	oauthProviders[name] = provider
+}
+
+func (t OAuthToken) Empty() bool {
+	return t.AccessToken == "" && t.RefreshToken == ""
+}
+
+func (t OAuthToken) oauth2Token() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		Expiry:       t.Expiry,
+	}
+}
+
+func newOAuthToken(token *oauth2.Token) OAuthToken {
+	return OAuthToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	}
+}
+
+func GenerateOAuthToken(service string, configPath string) (*Config, error) {
+	// First read config to get client credentials
+	config, err := ParseConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, ok := oauthProviders[service]
+	if !ok {
+		return nil, fmt.Errorf("unsupported service: %s", service)
+	}
+	oauthConfig, slot := provider(config)
+
+	// Start HTTP server to handle the OAuth callback
+	codeChan := make(chan string)
+	mux := http.NewServeMux()
+	srv := &http.Server{Addr: ":3000", Handler: mux}
+
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		code := r.URL.Query().Get("code")
+		codeChan <- code
+		fmt.Fprintf(w, "Authorization successful! You can close this window.")
+		go func() {
+			srv.Shutdown(context.Background())
+		}()
+	})
+
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			fmt.Printf("HTTP server error: %v\n", err)
+		}
+	}()
+
+	// Request offline access so a refresh token is issued
+	authURL := oauthConfig.AuthCodeURL("state", oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+	fmt.Printf("Opening browser for authorization...\n")
+	fmt.Printf("Please visit this URL to authorize: %v\n", authURL)
+
+	// Wait for the authorization code
+	code := <-codeChan
+
+	token, err := oauthConfig.Exchange(context.Background(), code)
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging code: %v", err)
+	}
+
+	// Update token and write config
+	*slot = newOAuthToken(token)
+	if err := SaveConfig(configPath, config); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Successfully added %s token in: %s\n", service, configPath)
+	return config, nil
+}
+
+// NewOAuthClient returns an HTTP client that authorizes requests with the
+// service's stored token, refreshing it when expired and writing refreshed
+// tokens back to the config file.
+func NewOAuthClient(service string, config *Config) *http.Client {
+	provider, ok := oauthProviders[service]
+	if !ok {
+		return &http.Client{}
+	}
+	oauthConfig, slot := provider(config)
+
+	ctx := context.Background()
+	source := &savingTokenSource{
+		service:    service,
+		configPath: config.path,
+		base:       oauthConfig.TokenSource(ctx, slot.oauth2Token()),
+		slot:       slot,
+	}
+	return oauth2.NewClient(ctx, source)
+}
+
+type savingTokenSource struct {
+	service    string
+	configPath string
+	base       oauth2.TokenSource
+	mu         sync.Mutex
+	slot       *OAuthToken
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken == s.slot.AccessToken {
+		return token, nil
+	}
+
+	// Token was refreshed, persist it
+	*s.slot = newOAuthToken(token)
+	config, err := ParseConfig(s.configPath)
+	if err != nil {
+		fmt.Printf("Error saving refreshed token: %v\n", err)
+		return token, nil
+	}
+	_, stored := oauthProviders[s.service](config)
+	*stored = *s.slot
+	if err := SaveConfig(s.configPath, config); err != nil {
+		fmt.Printf("Error saving refreshed token: %v\n", err)
+	}
+	return token, nil
+}
+
+// isAuthError reports whether err came from a failed token refresh or a
+// rejected token, in which case the browser flow has to run again.
+func isAuthError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) || errors.Is(err, ErrAuth)
+}
+
+// Whether an expired or revoked token may be replaced by running the
+// browser flow. The daemon turns this off as nobody is there to log in.
+var interactiveAuth = true
+
+// reauthorize runs the browser flow for service and returns the updated
+// config with a client using the new token.
+func reauthorize(service string, configPath string) (*Config, *http.Client, error) {
+	if !interactiveAuth {
+		return nil, nil, fmt.Errorf("%s token is invalid, run 'playlistty auth %s' to log in again", service, service)
+	}
+	config, err := GenerateOAuthToken(service, configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, NewOAuthClient(service, config), nil
+}
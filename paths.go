@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const appName = "playlistty"
+
+// Location used before configs moved under the XDG config directory
+const legacyConfigFile = "config/config.yml"
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/playlistty/config.yml, falling
+// back to ~/.config. A config left in the old ./config directory is still
+// used when no XDG config exists yet.
+func DefaultConfigPath() string {
+	path := filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), appName, "config.yml")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(legacyConfigFile); err == nil {
+			return legacyConfigFile
+		}
+	}
+	return path
+}
+
+// CacheDir returns $XDG_CACHE_HOME/playlistty, falling back to ~/.cache.
+func CacheDir() string {
+	return filepath.Join(xdgDir("XDG_CACHE_HOME", ".cache"), appName)
+}
+
+// DataDir returns $XDG_DATA_HOME/playlistty, falling back to ~/.local/share.
+func DataDir() string {
+	return filepath.Join(xdgDir("XDG_DATA_HOME", filepath.Join(".local", "share")), appName)
+}
+
+func xdgDir(env string, fallback string) string {
+	if dir := os.Getenv(env); dir != "" && filepath.IsAbs(dir) {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fallback
+	}
+	return filepath.Join(home, fallback)
+}
+
+// EnsureConfig creates an empty config file at path if none exists.
+func EnsureConfig(path string) error {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating config directory: %v", err)
+	}
+
+	// Create empty config struct
+	if err := SaveConfig(path, &Config{}); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created new config file: %s\n", path)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TransferPlan lists what a transfer would change on the target without
+// making any of the changes.
+type TransferPlan struct {
+	SourceService string  `json:"source_service"`
+	SourceID      string  `json:"source_id"`
+	TargetService string  `json:"target_service"`
+	TargetID      string  `json:"target_id,omitempty"`
+	TargetName    string  `json:"target_name,omitempty"`
+	Mode          string  `json:"mode"`
+	Remove        []Track `json:"remove"`
+	Add           []Track `json:"add"`
+	Unmatched     []Track `json:"unmatched"`
+
+	// Reorders needed after the changes above, sync mode only
+	Moves []TrackMove `json:"moves,omitempty"`
+}
+
+// BuildPlan compares the matched tracks in file against the current
+// contents of the target playlist. An empty playlist ID plans a new
+// playlist called name.
+func BuildPlan(target MusicService, playlist string, name string, file string, mode string) (*TransferPlan, error) {
+	source, err := LoadPlaylistFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &TransferPlan{
+		SourceService: source.Service,
+		SourceID:      source.ID,
+		TargetService: target.Name(),
+		TargetID:      playlist,
+		TargetName:    name,
+		Mode:          mode,
+		Remove:        []Track{},
+		Add:           []Track{},
+		Unmatched:     []Track{},
+	}
+
+	var matched []Track
+	for _, track := range source.Tracks {
+		if track.TargetID == "" {
+			plan.Unmatched = append(plan.Unmatched, track)
+		} else {
+			matched = append(matched, track)
+		}
+	}
+
+	// New playlists start empty
+	if playlist == "" {
+		plan.Add = append(plan.Add, matched...)
+		return plan, nil
+	}
+	current, err := target.ReadPlaylist(playlist)
+	if err != nil {
+		return nil, err
+	}
+	plan.TargetName = current.Name
+
+	// Everything currently on an existing target gets cleared
+	if mode != modeSync {
+		plan.Remove = append(plan.Remove, current.Tracks...)
+		plan.Add = append(plan.Add, matched...)
+		return plan, nil
+	}
+
+	desired := source.TargetTracks()
+	diff := DiffPlaylist(current.Tracks, desired)
+	plan.Remove = diff.Remove
+
+	// Report additions with their source track and score
+	i := 0
+	for _, track := range matched {
+		if i < len(diff.Add) && track.TargetID == diff.Add[i].ID {
+			plan.Add = append(plan.Add, track)
+			i++
+		}
+	}
+
+	// Simulate the removals and appends to find the reorders
+	plan.Moves = PlanMoves(diff.Apply(current.Tracks), desired)
+	return plan, nil
+}
+
+func (p *TransferPlan) Print() {
+	fmt.Printf("Dry run: %s:%s -> ", p.SourceService, p.SourceID)
+	if p.TargetID == "" {
+		fmt.Printf("new %s playlist %q\n", p.TargetService, p.TargetName)
+	} else {
+		fmt.Printf("%s:%s (%s)\n", p.TargetService, p.TargetID, p.TargetName)
+	}
+
+	fmt.Printf("\nTracks to remove (%d):\n", len(p.Remove))
+	for _, track := range p.Remove {
+		fmt.Printf("- %s by %s (ID: %s)\n", track.Name, track.Artist(), track.ID)
+	}
+
+	fmt.Printf("\nTracks to add (%d):\n", len(p.Add))
+	for _, track := range p.Add {
+		fmt.Printf("- [%s] %s by %s -> %s\n", formatScore(track.MatchScore), track.Name, track.Artist(), track.TargetID)
+	}
+
+	if p.Mode == modeSync && p.TargetID != "" {
+		fmt.Printf("\nTracks to move (%d):\n", len(p.Moves))
+		for _, move := range p.Moves {
+			fmt.Printf("- %s by %s (%d -> %d)\n", move.Track.Name, move.Track.Artist(), move.From+1, move.To+1)
+		}
+	}
+
+	fmt.Printf("\nUnmatched tracks (%d):\n", len(p.Unmatched))
+	for _, track := range p.Unmatched {
+		if track.MatchScore > 0 {
+			fmt.Printf("- %s by %s (best candidate %s)\n", track.Name, track.Artist(), formatScore(track.MatchScore))
+		} else {
+			fmt.Printf("- %s by %s\n", track.Name, track.Artist())
+		}
+	}
+	fmt.Println("\nNo changes were made")
+}
+
+func (p *TransferPlan) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating plan directory: %v", err)
+	}
+	data, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling plan: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing plan: %v", err)
+	}
+	fmt.Printf("Wrote plan to %s\n", path)
+	return nil
+}
+
+// PreviewTransfer prints the plan for a prepared transfer and optionally
+// writes it as JSON.
+func PreviewTransfer(target MusicService, playlist string, name string, file string, mode string, planFile string) error {
+	plan, err := BuildPlan(target, playlist, name, file, mode)
+	if err != nil {
+		return err
+	}
+	plan.Print()
+	if planFile != "" {
+		return plan.Save(planFile)
+	}
+	return nil
+}
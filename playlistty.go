@@ -3,37 +3,42 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"gopkg.in/yaml.v3"
-	"net/http"
 	"os"
 	"strings"
+	"sync"
 )
 
-const storageDir = "config"
-const configFile = storageDir + "/config.yml"
-
 type Config struct {
 	Spotify struct {
 		UserID       string `yaml:"user_id"`
 		ClientID     string `yaml:"client_id"`
 		ClientSecret string `yaml:"client_secret"`
-		Token        string `yaml:"token"`
+		OAuthToken   `yaml:",inline"`
 	} `yaml:"spotify"`
 	YouTube struct {
 		ClientID     string `yaml:"client_id"`
 		ClientSecret string `yaml:"client_secret"`
-		Token        string `yaml:"token"`
+		OAuthToken   `yaml:",inline"`
 	} `yaml:"youtube"`
+	Matching MatchingConfig `yaml:"matching"`
+	Sync     SyncConfig     `yaml:"sync"`
+	Daemon   DaemonConfig   `yaml:"daemon"`
+	Quota    QuotaConfig    `yaml:"quota"`
+
+	// File the config was loaded from, refreshed tokens are saved back here
+	path string
 }
 type Flags struct {
 	Service      string
 	ConfigPath   string
 	OAuthService string
+	Mode         string
+	DryRun       bool
+	PlanFile     string
 }
 type App struct {
 	HostService       string
@@ -45,50 +50,31 @@ type App struct {
 	TargetID          string
 }
 
-func Run(service string) *App {
+func Run(flags *Flags) (*App, error) {
 	app := &App{}
-	platforms := []string{"spotify", "youtube"}
+	service, configPath := flags.Service, flags.ConfigPath
+	platforms := ServiceNames()
 	fmt.Println("Welcome to playlistty!")
 	// Checks for config file
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		err := os.MkdirAll(storageDir, 0755)
-		if err != nil {
-			fmt.Printf("Error creating config directory: %v\n", err)
-			return app
-		}
-
-		// Create empty config struct
-		config := Config{}
-
-		// Marshal to YAML
-		data, err := yaml.Marshal(&config)
-		if err != nil {
-			fmt.Printf("Error creating config file: %v\n", err)
-			return app
-		}
-
-		// Write config file
-		err = os.WriteFile(configFile, data, 0644)
-		if err != nil {
-			fmt.Printf("Error writing config file: %v\n", err)
-			return app
-		}
-
-		fmt.Printf("Created new config file: %s\n", configFile)
+	if err := EnsureConfig(configPath); err != nil {
+		return app, fmt.Errorf("error creating config file: %w", err)
 	}
-	switch service {
-	case "spotify":
-		app.HostService = "spotify"
-	case "youtube":
-		app.HostService = "youtube"
+	host, err := GetService(service, configPath)
+	if err != nil {
+		return app, fmt.Errorf("error loading service: %w", err)
 	}
+	app.HostService = host.Name()
 
 	// Signin
-	ValidateToken(app.HostService)
+	if err := host.ValidateAuth(); err != nil {
+		return app, fmt.Errorf("error validating token: %w", err)
+	}
 	app.HostValidated = true
 
 	// List playlists
-	ListPlaylists(app.HostService)
+	if err := ListPlaylists(host); err != nil {
+		return app, fmt.Errorf("error listing playlists: %w", err)
+	}
 
 	// Choose playlist
 	fmt.Printf("\nEnter Playlist id: ")
@@ -103,17 +89,43 @@ func Run(service string) *App {
 	fmt.Printf("Enter number 1-%d: ", len(platforms))
 	fmt.Scanln(&choice)
 	if choice < 1 || choice > len(platforms) {
-		fmt.Println("Invalid choice")
-		return app
+		return app, fmt.Errorf("invalid choice: must be 1-%d", len(platforms))
 	}
 	app.TargetService = platforms[choice-1]
+	target, err := GetService(app.TargetService, configPath)
+	if err != nil {
+		return app, fmt.Errorf("error loading service: %w", err)
+	}
+	if target.Name() != host.Name() {
+		if err := target.ValidateAuth(); err != nil {
+			return app, fmt.Errorf("error validating token: %w", err)
+		}
+	}
 
 	// Read and parse host playlist
-	fmt.Printf("Parsing playlist: %s\n", app.HostPlaylist)
-	ReadPlaylist(app.HostService, app.HostPlaylist)
-	if app.HostService != "youtube" || app.TargetService != "youtube" { 
-		PlaylistFile := storageDir + "/" + app.HostService + "/" + app.HostPlaylist + ".json"
-		FindTrackIDFromFile(app.TargetService, PlaylistFile)
+	matcher, err := LoadMatcher(target, configPath)
+	if err != nil {
+		return app, fmt.Errorf("error reading config: %w", err)
+	}
+	ctx, stop := interruptContext()
+	PlaylistFile, err := PrepareTransfer(ctx, host, matcher, app.HostPlaylist)
+	stop()
+	if err != nil {
+		return app, fmt.Errorf("error preparing transfer: %w", err)
+	}
+
+	// Offer to fix unmatched and low confidence tracks
+	if count, err := CountReview(matcher, PlaylistFile); err == nil && count > 0 {
+		fmt.Printf("\n%d tracks are unmatched or low confidence. Review them?\n", count)
+		fmt.Println("1. Yes")
+		fmt.Println("2. No")
+		var review int
+		fmt.Scanln(&review)
+		if review == 1 {
+			if err := ReviewMatches(context.Background(), matcher, PlaylistFile, bufio.NewReader(os.Stdin)); err != nil {
+				return app, fmt.Errorf("error reviewing matches: %w", err)
+			}
+		}
 	}
 
 	// ask to create or use existing playlist
@@ -137,91 +149,78 @@ func Run(service string) *App {
 		app.TargetName, _ = reader.ReadString('\n')
 		app.TargetName = strings.TrimSpace(app.TargetName)
 		fmt.Println("Playlist will default to private")
-		CreatePlaylist(app.TargetService, app.TargetName, "Made with Playlistty", false)
+		if flags.DryRun {
+			// Nothing to choose, the plan targets the playlist that would be created
+			if err := PreviewTransfer(target, "", app.TargetName, PlaylistFile, flags.Mode, flags.PlanFile); err != nil {
+				return app, fmt.Errorf("error building plan: %w", err)
+			}
+			return app, nil
+		}
+		if _, err := target.CreatePlaylist(app.TargetName, "Made with Playlistty", false); err != nil {
+			fmt.Printf("Error creating playlist: %v\n", err)
+		}
+	}
+	if !flags.DryRun && flags.Mode != modeSync {
+		fmt.Println("WARNING IT WILL CLEAR PLAYLIST")
 	}
-	fmt.Println("WARNING IT WILL CLEAR PLAYLIST")
 	fmt.Println("Choose target playlist:")
-	ListPlaylists(app.TargetService)
+	if err := ListPlaylists(target); err != nil {
+		return app, fmt.Errorf("error listing playlists: %w", err)
+	}
 	fmt.Printf("\nEnter Playlist id: ")
 	fmt.Scan(&app.TargetID)
-	ClearPlaylist(app.TargetService, app.TargetID)
-	fmt.Printf("Transferring playlist: %s\n", app.TargetID)
+
+	if flags.DryRun {
+		if err := PreviewTransfer(target, app.TargetID, "", PlaylistFile, flags.Mode, flags.PlanFile); err != nil {
+			return app, fmt.Errorf("error building plan: %w", err)
+		}
+		return app, nil
+	}
 
 	// Update playlist
-	UpdatePlaylist(app.TargetService, app.TargetID, app.TargetService, app.HostService, app.HostPlaylist)
-	return app
+	ctx, stop = interruptContext()
+	err = ApplyTransfer(ctx, target, app.TargetID, PlaylistFile, flags.Mode)
+	stop()
+
+	// Remember the pair so it can be synced later
+	link := Link{SourceService: host.Name(), SourceID: app.HostPlaylist, TargetService: target.Name(), TargetID: app.TargetID, Mode: flags.Mode}
+	if linkErr := RecordLink(configPath, link, err == nil); linkErr != nil {
+		fmt.Printf("Error saving link: %v\n", linkErr)
+	}
+	if err != nil {
+		return app, fmt.Errorf("error transferring playlist: %w", err)
+	}
+	return app, nil
 }
 
 func Setup() {
 
 }
 
-func ValidateToken(service string) {
-	switch service {
-	case "spotify":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return
-		}
-
-		// Test token by making a request
-		url := "https://api.spotify.com/v1/me"
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return
-		}
-
-		req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == 200 {
-			fmt.Println("Token is valid")
-			return
-		} else {
-			GenerateOAuthToken("spotify")
-			return
-		}
-	case "youtube":
-	}
-}
-
 func ParseFlags() (*Flags, error) {
 	flags := &Flags{}
-	services := []string{"spotify", "yt"}
 	// Define flags
 	flag.StringVar(&flags.Service, "service", "", "spotify/yt")
-	flag.StringVar(&flags.ConfigPath, "config", configFile, "Path to config file")
+	flag.StringVar(&flags.ConfigPath, "config", DefaultConfigPath(), "Path to config file")
 	flag.StringVar(&flags.OAuthService, "oauth", "", "Generate OAuth Token for service")
+	flag.StringVar(&flags.Mode, "mode", modeReplace, "How to update an existing target: replace clears it first, sync applies only the differences")
+	flag.BoolVar(&flags.DryRun, "dry-run", false, "Show the planned changes without modifying the target")
+	flag.StringVar(&flags.PlanFile, "plan", "", "With -dry-run, also write the plan as JSON to this file")
 	helpFlag := flag.Bool("help", false, "Shows help screen")
 	// Parse flags
 	flag.Parse()
-	if flags.Service != "" || flags.OAuthService != "" {
-		// Validate service flag
-		found := false
-		for _, service := range services {
-			if flags.Service == service {
-				found = true
-				break
-			}
-			if flags.OAuthService == service {
-				found = true
-				break
-			}
+	// Validate service flags
+	for _, service := range []string{flags.Service, flags.OAuthService} {
+		if service == "" {
+			continue
 		}
-		if !found {
-			return nil, fmt.Errorf("invalid service: must be one of %v", services)
+		if _, ok := registry[serviceName(service)]; !ok {
+			return nil, fmt.Errorf("invalid service: must be one of %v", ServiceNames())
 		}
 	}
+	if !validMode(flags.Mode) {
+		return nil, fmt.Errorf("invalid mode: must be %s or %s", modeReplace, modeSync)
+	}
 	switch *helpFlag {
 	case true:
 		flag.Usage()
@@ -244,1047 +243,225 @@ func ParseConfig(configPath string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("error parseing config file: %v", err)
 	}
+	config.path = configPath
 	return &config, nil
 }
 
-func GenerateOAuthToken(service string) (*Config, error) {
-	// First read config to get client credentials
-	configData, err := os.ReadFile(configFile)
+func SaveConfig(configPath string, config *Config) error {
+	// Marshal to YAML
+	data, err := yaml.Marshal(config)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config: %v", err)
+		return fmt.Errorf("error marshaling config: %v", err)
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(configData, &config); err != nil {
-		return nil, fmt.Errorf("error parsing config: %v", err)
-	}
-
-	switch service {
-	case "youtube":
-		// OAuth2 config
-		gConfig := &oauth2.Config{
-			ClientID:     config.YouTube.ClientID,
-			ClientSecret: config.YouTube.ClientSecret,
-			RedirectURL:  "http://localhost:3000/callback",
-			Scopes:       []string{"https://www.googleapis.com/auth/youtube"},
-			Endpoint:     google.Endpoint,
-		}
-
-		// Start HTTP server to handle the OAuth callback
-		codeChan := make(chan string)
-		srv := &http.Server{Addr: ":3000"}
-
-		http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-			code := r.URL.Query().Get("code")
-			codeChan <- code
-			fmt.Fprintf(w, "Authorization successful! You can close this window.")
-			go func() {
-				srv.Shutdown(context.Background())
-			}()
-		})
-
-		go func() {
-			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-				fmt.Printf("HTTP server error: %v\n", err)
-			}
-		}()
-
-		authURL := gConfig.AuthCodeURL("state")
-		fmt.Printf("Opening browser for authorization...\n")
-		fmt.Printf("Please visit this URL to authorize: %v\n", authURL)
-
-		// Wait for the authorization code
-		code := <-codeChan
-
-		ctx := context.Background()
-		token, err := gConfig.Exchange(ctx, code)
-		if err != nil {
-			return nil, fmt.Errorf("error exchanging code: %v", err)
-		}
-
-		// Update token
-		config.YouTube.Token = token.AccessToken
-
-		// Write updated config
-		newConfigData, err := yaml.Marshal(&config)
-		if err != nil {
-			return nil, fmt.Errorf("error marshaling config: %v", err)
-		}
-
-		if err := os.WriteFile(configFile, newConfigData, 0644); err != nil {
-			return nil, fmt.Errorf("error writing config: %v", err)
-		}
-
-		fmt.Printf("Sucessfully added YouTube token in: %s\n", configFile)
-		return &config, nil
-	case "spotify":
-		// OAuth2 config
-		gConfig := &oauth2.Config{
-			ClientID:     config.Spotify.ClientID,
-			ClientSecret: config.Spotify.ClientSecret,
-			RedirectURL:  "http://localhost:3000/callback",
-			Scopes: []string{
-				"playlist-modify-public",
-				"playlist-modify-private",
-				"playlist-read-private",
-				"playlist-read-collaborative",
-			},
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  "https://accounts.spotify.com/authorize",
-				TokenURL: "https://accounts.spotify.com/api/token",
-			},
-		}
-
-		// Start HTTP server to handle the OAuth callback
-		codeChan := make(chan string)
-		srv := &http.Server{Addr: ":3000"}
-
-		http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-			code := r.URL.Query().Get("code")
-			codeChan <- code
-			fmt.Fprintf(w, "Authorization successful! You can close this window.")
-			go func() {
-				srv.Shutdown(context.Background())
-			}()
-		})
-
-		go func() {
-			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-				fmt.Printf("HTTP server error: %v\n", err)
-			}
-		}()
-
-		authURL := gConfig.AuthCodeURL("state")
-		fmt.Printf("Opening browser for authorization...\n")
-		fmt.Printf("Please visit this URL to authorize: %v\n", authURL)
-
-		// Wait for the authorization code
-		code := <-codeChan
-
-		ctx := context.Background()
-		token, err := gConfig.Exchange(ctx, code)
-		if err != nil {
-			return nil, fmt.Errorf("error exchanging code: %v", err)
-		}
-
-		// Update token in config
-		config.Spotify.Token = token.AccessToken
-
-		// Write updated config
-		newConfigData, err := yaml.Marshal(&config)
-		if err != nil {
-			return nil, fmt.Errorf("error marshaling config: %v", err)
-		}
-
-		if err := os.WriteFile(configFile, newConfigData, 0644); err != nil {
-			return nil, fmt.Errorf("error writing config: %v", err)
-		}
-
-		fmt.Printf("Successfully added Spotify token in: %s\n", configFile)
-		return &config, nil
-	default:
-		return nil, fmt.Errorf("unsupported service: %s", service)
+	// Tokens are secrets, keep the file private
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		return fmt.Errorf("error writing config: %v", err)
 	}
+	return nil
 }
 
-func ListPlaylists(service string) (config *Config) {
-	switch service {
-	case "spotify":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return nil
-		}
-
-		// Create request URL for Spotify playlists endpoint
-		url := fmt.Sprintf("https://api.spotify.com/v1/users/%s/playlists", config.Spotify.UserID)
-
-		// Create request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return nil
-		}
-
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-
-		// Make request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return nil
-		}
-		defer resp.Body.Close()
-
-		// Parse response
-		var playlists struct {
-			Items []struct {
-				Name string `json:"name"`
-				ID   string `json:"id"`
-			} `json:"items"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&playlists); err != nil {
-			fmt.Printf("Error decoding response: %v\n", err)
-			return nil
-		}
-
-		// Print playlists
-		fmt.Println("Your Spotify playlists:")
-		for _, playlist := range playlists.Items {
-			fmt.Printf("- %s (ID: %s)\n", playlist.Name, playlist.ID)
-		}
-
-		return config
-	case "youtube":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return nil
-		}
-
-		// Create request URL for YouTube playlists endpoint
-		url := "https://www.googleapis.com/youtube/v3/playlists?part=snippet&mine=true"
-
-		// Create request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return nil
-		}
-
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.YouTube.Token)
-
-		// Make request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return nil
-		}
-		defer resp.Body.Close()
-
-		// Parse response
-		var playlists struct {
-			Items []struct {
-				Snippet struct {
-					Title string `json:"title"`
-				} `json:"snippet"`
-				Id string `json:"id"`
-			} `json:"items"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&playlists); err != nil {
-			fmt.Printf("Error decoding response: %v\n", err)
-			return nil
-		}
-
-		// Print playlists
-		fmt.Println("Your YouTube playlists:")
-		for _, playlist := range playlists.Items {
-			fmt.Printf("- %s (ID: %s)\n", playlist.Snippet.Title, playlist.Id)
-		}
-
-		return config
-	default:
-		fmt.Printf("Service %s not supported\n", service)
-		return nil
+func ListPlaylists(service MusicService) error {
+	playlists, err := service.ListPlaylists()
+	if err != nil {
+		return err
 	}
-}
-
-func ReadPlaylist(service string, playlist string) {
-	switch service {
-	case "spotify":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return
-		}
-
-		// Create request URL for Spotify playlist tracks endpoint
-		url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s", playlist)
-
-		// Create request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return
-		}
-
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-
-		// Make request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		// Parse playlist response to get name
-		var playlistData struct {
-			Name string `json:"name"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&playlistData); err != nil {
-			fmt.Printf("Error decoding playlist response: %v\n", err)
-			return
-		}
-
-		// Get tracks URL
-		tracksURL := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlist)
-
-		// Create tracks request
-		req, err = http.NewRequest("GET", tracksURL, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return
-		}
-
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-
-		// Make request
-		resp, err = client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		// Parse response
-		var tracks struct {
-			Items []struct {
-				Track struct {
-					Name    string `json:"name"`
-					Artists []struct {
-						Name string `json:"name"`
-					} `json:"artists"`
-				} `json:"track"`
-			} `json:"items"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&tracks); err != nil {
-			fmt.Printf("Error decoding response: %v\n", err)
-			return
-		}
-
-		// Extract songs into list
-		var songList []map[string]string
-		for _, item := range tracks.Items {
-			artists := make([]string, len(item.Track.Artists))
-			for i, artist := range item.Track.Artists {
-				artists[i] = artist.Name
-			}
-			songData := map[string]string{
-				"name":   item.Track.Name,
-				"artist": strings.Join(artists, ", "),
-			}
-			songList = append(songList, songData)
-		}
-
-		// Create storage directory if it doesn't exist
-		os.MkdirAll(storageDir+"/spotify", 0755)
-		filePath := fmt.Sprintf("%s/spotify/%s.json", storageDir, playlist)
-
-		// Write to file
-		jsonData, err := json.MarshalIndent(songList, "", "    ")
-		if err != nil {
-			fmt.Printf("Error marshaling song data: %v\n", err)
-			return
-		}
-
-		if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
-			fmt.Printf("Error writing song data file: %v\n", err)
-			return
-		}
-
-		// Print tracks
-		fmt.Printf("Tracks in playlist (%s):\n", playlistData.Name)
-		for _, item := range tracks.Items {
-			artists := make([]string, len(item.Track.Artists))
-			for i, artist := range item.Track.Artists {
-				artists[i] = artist.Name
-			}
-			fmt.Printf("- %s by %s\n", item.Track.Name, strings.Join(artists, ", "))
-		}
-	case "youtube":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return
-		}
-
-		var songList []map[string]string
-		var nextPageToken string
-
-		for {
-			// Create request URL
-			listURL := fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlistItems?part=snippet&maxResults=50&playlistId=%s", playlist)
-			if nextPageToken != "" {
-				listURL = fmt.Sprintf("%s&pageToken=%s", listURL, nextPageToken)
-			}
-
-			// Create request
-			req, err := http.NewRequest("GET", listURL, nil)
-			if err != nil {
-				fmt.Printf("Error creating request: %v\n", err)
-				return
-			}
-
-			// Add authorization header
-			req.Header.Add("Authorization", "Bearer "+config.YouTube.Token)
-
-			// Make request
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Printf("Error making request: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
-
-			// Parse response
-			var result struct {
-				NextPageToken string `json:"nextPageToken"`
-				Items []struct {
-					Snippet struct {
-						Title       string `json:"title"`
-						ChannelTitle string `json:"videoOwnerChannelTitle"`
-						ResourceId struct {
-							VideoId string `json:"videoId"`
-						} `json:"resourceId"`
-					} `json:"snippet"`
-				} `json:"items"`
-			}
-
-			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-				fmt.Printf("Error decoding response: %v\n", err)
-				return
-			}
-
-			// Extract songs from this page
-			for _, item := range result.Items {
-				songData := map[string]string{
-					"name": item.Snippet.Title,
-					"artist": strings.TrimSuffix(item.Snippet.ChannelTitle, " - Topic"),
-					"id":   item.Snippet.ResourceId.VideoId,
-				}
-				songList = append(songList, songData)
-			}
-
-			nextPageToken = result.NextPageToken
-			if nextPageToken == "" {
-				break
-			}
-		}
-
-		// Create storage directory if it doesn't exist
-		os.MkdirAll(storageDir+"/youtube", 0755)
-		filePath := fmt.Sprintf("%s/youtube/%s.json", storageDir, playlist)
 
-		// Write to file
-		jsonData, err := json.MarshalIndent(songList, "", "    ")
-		if err != nil {
-			fmt.Printf("Error marshaling song data: %v\n", err)
-			return
-		}
-
-		if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
-			fmt.Printf("Error writing song data file: %v\n", err)
-			return
-		}
-
-		// Print tracks
-		fmt.Println("Tracks in playlist:")
-		for _, song := range songList {
-			fmt.Printf("- %s by %s (ID: %s)\n", song["name"], song["artist"], song["id"])
-		}
-	default:
-		fmt.Printf("Service %s not supported\n", service)
+	// Print playlists
+	fmt.Printf("Your %s playlists:\n", service.DisplayName())
+	for _, playlist := range playlists {
+		fmt.Printf("- %s (ID: %s)\n", playlist.Name, playlist.ID)
 	}
+	return nil
 }
 
-func FindTrackIDFromFile(targetService string, file string) {
-	// Read song data from file
-	songData, err := os.ReadFile(file)
+func ReadPlaylist(service MusicService, playlist string) (*Playlist, error) {
+	result, err := service.ReadPlaylist(playlist)
 	if err != nil {
-		fmt.Printf("Error reading song file: %v\n", err)
-		return
+		return nil, err
 	}
+	result.Service = service.Name()
 
-	// Parse song data into slice of maps
-	var songs []map[string]string
-	if err := json.Unmarshal(songData, &songs); err != nil {
-		fmt.Printf("Error parsing song data: %v\n", err)
-		return
+	// Write to file
+	if err := SavePlaylistFile(playlistFilePath(service.Name(), playlist), result); err != nil {
+		return nil, err
 	}
 
-	// Search for each song and add ID
-	for i := range songs {
-		name := songs[i]["name"]
-		artist := songs[i]["artist"]
-
-		id := SearchSong(targetService, name, artist)
-		if id != "" {
-			songs[i]["id"] = id
-		}
+	// Print tracks
+	fmt.Printf("Tracks in playlist (%s):\n", result.Name)
+	for _, track := range result.Tracks {
+		fmt.Printf("- %s by %s (ID: %s)\n", track.Name, track.Artist(), track.ID)
 	}
+	return result, nil
+}
 
-	// Write updated data back to file
-	updatedData, err := json.MarshalIndent(songs, "", "    ")
+func FindTrackIDFromFile(ctx context.Context, matcher *Matcher, file string) error {
+	// Read song data from file
+	playlist, err := LoadPlaylistFile(file)
 	if err != nil {
-		fmt.Printf("Error marshaling updated song data: %v\n", err)
-		return
+		return err
 	}
+	target := matcher.Target
+	playlist.TargetService = target.Name()
 
-	if err := os.WriteFile(file, updatedData, 0644); err != nil {
-		fmt.Printf("Error writing updated song data: %v\n", err)
-		return
+	// Same service, nothing to search
+	if target.Name() == playlist.Service {
+		for i := range playlist.Tracks {
+			playlist.Tracks[i].TargetID = playlist.Tracks[i].ID
+			playlist.Tracks[i].MatchScore = 1
+		}
+		fmt.Printf("Matched %d of %d tracks\n", len(playlist.Tracks), len(playlist.Tracks))
+		return SavePlaylistFile(file, playlist)
 	}
 
-}
-
-func UpdatePlaylist(service string, playlist string, mode string, folder string, trackID string) {
-	switch service {
-	case "spotify":
-		switch mode {
-		case "add":
-			// Parse config file
-			config, err := ParseConfig(configFile)
-			if err != nil {
-				fmt.Printf("Error reading config: %v\n", err)
-				return
-			}
-
-			// Create request URL for Spotify playlists endpoint
-			url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlist)
-
-			// Create request body with track URI
-			requestBody := map[string]interface{}{
-				"uris": []string{fmt.Sprintf("spotify:track:%s", trackID)},
-			}
-			bodyJSON, err := json.Marshal(requestBody)
-			if err != nil {
-				fmt.Printf("Error marshaling request body: %v\n", err)
-				return
-			}
-
-			// Create request
-			req, err := http.NewRequest("POST", url, strings.NewReader(string(bodyJSON)))
-			if err != nil {
-				fmt.Printf("Error creating request: %v\n", err)
-				return
-			}
-
-			// Add authorization header
-			req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-			req.Header.Add("Content-Type", "application/json")
-
-			// Make request
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Printf("Error making request: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode == 201 {
-				fmt.Printf("Successfully added track to playlist\n")
-			} else {
-				fmt.Printf("Error adding track to playlist: %s\n", resp.Status)
-			}
-		case "spotify":
-			// Parse config file
-			config, err := ParseConfig(configFile)
-			if err != nil {
-				fmt.Printf("Error reading config: %v\n", err)
-				return
-			}
-
-			// Read song data from file
-			filePath := fmt.Sprintf("%s/%s/%s.json", storageDir,folder, trackID)
-			songData, err := os.ReadFile(filePath)
-			if err != nil {
-				fmt.Printf("Error reading song data file: %v\n", err)
+	// Search for songs on several workers, their results are recorded in
+	// playlist order as soon as every earlier track is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		best ScoredTrack
+		ok   bool
+		err  error
+	}
+	results := make([]result, len(playlist.Tracks))
+	jobs := make(chan int)
+	done := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range playlist.Tracks {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
 				return
 			}
-
-			// Parse song data
-			var songs []map[string]string
-			if err := json.Unmarshal(songData, &songs); err != nil {
-				fmt.Printf("Error parsing song data: %v\n", err)
-				return
+		}
+	}()
+	var wg sync.WaitGroup
+	for range matcher.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				best, ok, err := matcher.Resolve(ctx, playlist.Service, playlist.Tracks[i])
+				results[i] = result{best, ok, err}
+				done <- i
 			}
-
-			// Add each track to playlist
-			for _, song := range songs {
-				if song["id"] != "" {
-					// Create request URL
-					url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlist)
-
-					// Create request body
-					requestBody := map[string]interface{}{
-						"uris": []string{fmt.Sprintf("spotify:track:%s", song["id"])},
-					}
-					bodyJSON, err := json.Marshal(requestBody)
-					if err != nil {
-						fmt.Printf("Error marshaling request body: %v\n", err)
-						continue
-					}
-
-					// Create request
-					req, err := http.NewRequest("POST", url, strings.NewReader(string(bodyJSON)))
-					if err != nil {
-						fmt.Printf("Error creating request: %v\n", err)
-						continue
-					}
-
-					// Add headers
-					req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-					req.Header.Add("Content-Type", "application/json")
-
-					// Make request
-					client := &http.Client{}
-					resp, err := client.Do(req)
-					if err != nil {
-						fmt.Printf("Error making request: %v\n", err)
-						continue
-					}
-					defer resp.Body.Close()
-
-					if resp.StatusCode == 201 {
-						fmt.Printf("Added track: %s by %s\n", song["name"], song["artist"])
-					} else {
-						fmt.Printf("Error adding track %s: %s\n", song["name"], resp.Status)
-					}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Record the best match above the threshold for each song
+	matched, next := 0, 0
+	finished := make([]bool, len(playlist.Tracks))
+	var quotaErr error
+	for i := range done {
+		finished[i] = true
+		for ; next < len(playlist.Tracks) && finished[next]; next++ {
+			track := &playlist.Tracks[next]
+			best, ok, err := results[next].best, results[next].ok, results[next].err
+			if errors.Is(err, ErrQuota) {
+				// Stop searching, the rest would fail the same way
+				if quotaErr == nil {
+					quotaErr = fmt.Errorf("error matching %s: %w", track.Name, err)
+					cancel()
 				}
+				continue
 			}
-
-			fmt.Println("Finished adding tracks to playlist")
-
-		}
-	case "youtube":
-		switch mode {
-		case "youtube":
-			// Parse config file
-			config, err := ParseConfig(configFile)
-			if err != nil {
-				fmt.Printf("Error reading config: %v\n", err)
-				return
+			if err != nil && ctx.Err() != nil {
+				// Cancelled while searching, the track is matched next run
+				break
 			}
-
-			// Read song data from file
-			filePath := fmt.Sprintf("%s/%s/%s.json", storageDir,folder, trackID)
-			songData, err := os.ReadFile(filePath)
 			if err != nil {
-				fmt.Printf("Error reading song data file: %v\n", err)
-				return
+				fmt.Printf("Error searching for %s: %v\n", track.Name, err)
 			}
-
-			// Parse song data
-			var songs []map[string]string
-			if err := json.Unmarshal(songData, &songs); err != nil {
-				fmt.Printf("Error parsing song data: %v\n", err)
-				return
+			track.TargetID = ""
+			track.MatchScore = best.Score
+			track.Candidates = nil
+			if ok {
+				track.TargetID = best.Track.ID
 			}
 
-			// Collect all video IDs
-			var videoIds []string
-			for _, song := range songs {
-				if song["id"] != "" {
-					videoIds = append(videoIds, song["id"])
-				}
+			// Keep the candidates so reviewing does not search again
+			if matcher.needsReview(*track) {
+				track.Candidates = best.Ranked
 			}
-
-			// Add tracks one at a time
-			url := "https://www.googleapis.com/youtube/v3/playlistItems?part=snippet"
-			client := &http.Client{}
-
-			for _, videoId := range videoIds {
-				requestBody := map[string]interface{}{
-					"snippet": map[string]interface{}{
-						"playlistId": playlist,
-						"resourceId": map[string]string{
-							"kind":    "youtube#video",
-							"videoId": videoId,
-						},
-					},
-				}
-
-				bodyJSON, err := json.Marshal(requestBody)
-				if err != nil {
-					fmt.Printf("Error marshaling request body: %v\n", err)
-					continue
-				}
-
-				req, err := http.NewRequest("POST", url, strings.NewReader(string(bodyJSON)))
-				if err != nil {
-					fmt.Printf("Error creating request: %v\n", err)
-					continue
-				}
-
-				req.Header.Add("Authorization", "Bearer "+config.YouTube.Token)
-				req.Header.Add("Content-Type", "application/json")
-
-				resp, err := client.Do(req)
-				if err != nil {
-					fmt.Printf("Error making request: %v\n", err)
-					continue
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode == 200 {
-					fmt.Printf("Added video: %s\n", videoId)
+			if !ok {
+				if best.Track.ID != "" {
+					fmt.Printf("No match for %s by %s (best: %s by %s, %s)\n", track.Name, track.Artist(), best.Track.Name, best.Track.Artist(), formatScore(best.Score))
 				} else {
-					fmt.Printf("Error adding video %s: %s\n", videoId, resp.Status)
+					fmt.Printf("No match for %s by %s\n", track.Name, track.Artist())
 				}
+				continue
 			}
-
-			fmt.Println("Finished adding tracks to playlist")
-
-		}
-	}
-}
-
-func ClearPlaylist(service string, playlist string) {
-	switch service {
-	case "spotify":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return
-		}
-
-		// Get all tracks in playlist first
-		getUrl := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlist)
-		req, err := http.NewRequest("GET", getUrl, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return
-		}
-		req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		var tracks struct {
-			Items []struct {
-				Track struct {
-					URI string `json:"uri"`
-				} `json:"track"`
-			} `json:"items"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&tracks); err != nil {
-			fmt.Printf("Error decoding response: %v\n", err)
-			return
-		}
-
-		// Delete tracks in batches of 100
-		deleteUrl := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlist)
-		for i := 0; i < len(tracks.Items); i += 100 {
-			end := i + 100
-			if end > len(tracks.Items) {
-				end = len(tracks.Items)
-			}
-
-			// Create array of track URIs to delete
-			var trackList []map[string]interface{}
-			for _, item := range tracks.Items[i:end] {
-				trackList = append(trackList, map[string]interface{}{
-					"uri": item.Track.URI,
-				})
-			}
-
-			requestBody := map[string]interface{}{
-				"tracks": trackList,
-			}
-			bodyJSON, err := json.Marshal(requestBody)
-			if err != nil {
-				fmt.Printf("Error marshaling request body: %v\n", err)
-				return
-			}
-
-			req, err := http.NewRequest("DELETE", deleteUrl, strings.NewReader(string(bodyJSON)))
-			if err != nil {
-				fmt.Printf("Error creating request: %v\n", err)
-				return
-			}
-
-			req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-			req.Header.Add("Content-Type", "application/json")
-
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Printf("Error making request: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != 200 {
-				fmt.Printf("Error clearing playlist batch: %s\n", resp.Status)
-				return
+			matched++
+			if best.Cached {
+				fmt.Printf("Matched %s by %s -> %s (%s, cached)\n", track.Name, track.Artist(), best.Track.ID, formatScore(best.Score))
+				continue
 			}
+			fmt.Printf("Matched %s by %s -> %s by %s (%s)\n", track.Name, track.Artist(), best.Track.Name, best.Track.Artist(), formatScore(best.Score))
 		}
-
-		fmt.Printf("Successfully cleared playlist\n")
-	case "youtube":
-		
 	}
-}
 
-func CreatePlaylist(service string, title string, description string, public bool) {
-	switch service {
-	case "spotify":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
+	// Keep what was matched so far for the next run
+	if matcher.Cache != nil {
+		if err := matcher.Cache.Save(); err != nil {
+			fmt.Printf("Error saving match cache: %v\n", err)
 		}
+	}
+	if quotaErr != nil {
+		return quotaErr
+	}
+	if next < len(playlist.Tracks) {
+		return fmt.Errorf("matching stopped after %d of %d tracks: %w", next, len(playlist.Tracks), ctx.Err())
+	}
+	fmt.Printf("Matched %d of %d tracks\n", matched, len(playlist.Tracks))
 
-		// Create request URL for Spotify playlists endpoint
-		url := fmt.Sprintf("https://api.spotify.com/v1/users/%s/playlists", config.Spotify.UserID)
-
-		// Create request body
-		requestBody := map[string]interface{}{
-			"name":        title,
-			"description": description,
-			"public":      public,
-		}
-		bodyJSON, err := json.Marshal(requestBody)
-		if err != nil {
-			fmt.Printf("Error marshaling request body: %v\n", err)
-			return
-		}
-
-		// Create request
-		req, err := http.NewRequest("POST", url, strings.NewReader(string(bodyJSON)))
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return
-		}
-
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-
-		// Make request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return
-		}
-		defer resp.Body.Close()
-	case "youtube":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return
-		}
-	
-		// Create request URL for YouTube playlists endpoint
-		url := "https://www.googleapis.com/youtube/v3/playlists?part=snippet"
-	
-		// Create request body
-		requestBody := map[string]interface{}{
-			"snippet": map[string]interface{}{
-				"title":       title,
-				"description": description,
-				"privacyStatus": func() string {
-					if public {
-						return "public"
-					}
-					return "private"
-				}(),
-			},
-		}
-	
-		bodyJSON, err := json.Marshal(requestBody)
-		if err != nil {
-			fmt.Printf("Error marshaling request body: %v\n", err)
-			return
-		}
-	
-		// Create request
-		req, err := http.NewRequest("POST", url, strings.NewReader(string(bodyJSON)))
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return
-		}
-	
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.YouTube.Token)
-		req.Header.Add("Content-Type", "application/json")
-	
-		// Make request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return
-		}
-		defer resp.Body.Close()
-	
-		if resp.StatusCode != 200 {
-			fmt.Printf("Error creating playlist: %s\n", resp.Status)
-			return
-		}
-	
-		fmt.Printf("Successfully created playlist %s\n", title)
+	// Write updated data back to file
+	return SavePlaylistFile(file, playlist)
+}
 
+// UpdatePlaylist adds the matched tracks of file to an empty playlist,
+// starting at the top and keeping source order. Progress is journaled so
+// an interrupted update can be resumed.
+func UpdatePlaylist(ctx context.Context, target MusicService, playlist string, file string) error {
+	cp, err := NewCheckpoint(target, playlist, file, modeReplace)
+	if err != nil {
+		return err
 	}
+	cp.Cleared = true
+	return cp.Run(ctx, target)
 }
 
-func SearchSong(service string, song string, artist string) string {
-	switch service {
-	case "spotify":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return ""
-		}
-
-		// Create search query
-		query := fmt.Sprintf("track:%s artist:%s", song, artist)
-		encodedQuery := strings.ReplaceAll(query, " ", "%20")
-		url := fmt.Sprintf("https://api.spotify.com/v1/search?q=%s&type=track&limit=1", encodedQuery)
-
-		// Create request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return ""
-		}
-
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.Spotify.Token)
-
-		// Make request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return ""
-		}
-		defer resp.Body.Close()
-
-		// Parse response
-		var result struct {
-			Tracks struct {
-				Items []struct {
-					ID      string `json:"id"`
-					Name    string `json:"name"`
-					Artists []struct {
-						Name string `json:"name"`
-					} `json:"artists"`
-				} `json:"items"`
-			} `json:"tracks"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			fmt.Printf("Error decoding response: %v\n", err)
-			return ""
-		}
-
-		// Print results
-		fmt.Println("Search results:")
-		if len(result.Tracks.Items) > 0 {
-			track := result.Tracks.Items[0]
-			artists := make([]string, len(track.Artists))
-			for j, artist := range track.Artists {
-				artists[j] = artist.Name
-			}
-			fmt.Printf("%s by %s (ID: %s)\n", track.Name, strings.Join(artists, ", "), track.ID)
-		}
-
-		// Return first track ID if found
-		if len(result.Tracks.Items) > 0 {
-			return result.Tracks.Items[0].ID
-		}
-		return ""
-	case "youtube":
-		// Parse config file
-		config, err := ParseConfig(configFile)
-		if err != nil {
-			fmt.Printf("Error reading config: %v\n", err)
-			return ""
-		}
-
-		// Create search query
-		query := fmt.Sprintf("%s %s", song, artist)
-		encodedQuery := strings.ReplaceAll(query, " ", "%20")
-		url := fmt.Sprintf("https://www.googleapis.com/youtube/v3/search?part=snippet&maxResults=1&q=%s&type=video", encodedQuery)
-
-		// Create request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Printf("Error creating request: %v\n", err)
-			return ""
-		}
-
-		// Add authorization header
-		req.Header.Add("Authorization", "Bearer "+config.YouTube.Token)
-
-		// Make request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Error making request: %v\n", err)
-			return ""
-		}
-		defer resp.Body.Close()
-
-		// Parse response
-		var result struct {
-			Items []struct {
-				Id struct {
-					VideoId string `json:"videoId"`
-				} `json:"id"`
-				Snippet struct {
-					Title        string `json:"title"`
-					ChannelTitle string `json:"channelTitle"`
-				} `json:"snippet"`
-			} `json:"items"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			fmt.Printf("Error decoding response: %v\n", err)
-			return ""
-		}
-
-		// Print results
-		fmt.Println("Search results:")
-		if len(result.Items) > 0 {
-			video := result.Items[0]
-			fmt.Printf("%s by %s (ID: %s)\n", video.Snippet.Title, video.Snippet.ChannelTitle, video.Id.VideoId)
-			return video.Id.VideoId
-		}
-
-		return ""
+func PrintBatchSummary(results []BatchResult) int {
+	added, failed := 0, 0
+	offset := 0
+	for i, batch := range results {
+		first, last := offset+1, offset+len(batch.Tracks)
+		offset = last
+		if batch.Err != nil {
+			failed += len(batch.Tracks)
+			fmt.Printf("Batch %d (tracks %d-%d): failed: %v\n", i+1, first, last, batch.Err)
+			for _, track := range batch.Tracks {
+				fmt.Printf("  - %s by %s\n", track.Name, track.Artist())
+			}
+			continue
+		}
+		added += len(batch.Tracks)
+		fmt.Printf("Batch %d (tracks %d-%d): added %d tracks\n", i+1, first, last, len(batch.Tracks))
 	}
-	return ""
+	fmt.Printf("Added %d of %d tracks in %d batches (%d failed)\n", added, added+failed, len(results), failed)
+	return failed
 }
 
 func main() {
+	// Subcommands, anything starting with a flag uses the legacy interface
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(RunCommand(os.Args[1], os.Args[2:]))
+	}
+
 	// parse flags
 	flags, err := ParseFlags()
 	if err != nil {
@@ -1292,24 +469,16 @@ func main() {
 		os.Exit(1)
 	}
 	// OAuth runner
-	switch flags.OAuthService {
-	case "yt":
-		GenerateOAuthToken("youtube")
-	case "spotify":
-		GenerateOAuthToken("spotify")
+	if flags.OAuthService != "" {
+		if _, err := GenerateOAuthToken(serviceName(flags.OAuthService), flags.ConfigPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating token: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Runs migrate process for host service
-	switch flags.Service {
-	case "spotify":
-		Run("spotify")
-	case "yt":
-		Run("youtube")
-		// ListPlaylists("youtube")
-		// SearchSong("youtube", "striptease", "carwash")
-		// ReadPlaylist("youtube", "PLe0T9j3Sn3hnvtiIxpfx2a0SIy84VSSl7")
-		// FindTrackIDFromFile("youtube", storageDir+"/youtube/PLe0T9j3Sn3hlWTqNWn6pP5jnRjVthBDBp.json")
-		
+	if flags.Service != "" {
+		flags.Service = serviceName(flags.Service)
+		Run(flags)
 	}
-
 }
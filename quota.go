@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Call types quota usage is counted by
+const (
+	quotaSearch = "search"
+	quotaRead   = "read"
+	quotaInsert = "insert"
+	quotaUpdate = "update"
+	quotaDelete = "delete"
+)
+
+// on_exceed value that pauses for the reset instead of refusing
+const quotaWait = "wait"
+
+// QuotaConfig limits how much of a service's daily quota playlistty uses.
+type QuotaConfig struct {
+	// Units to use a day, 0 for the service's whole quota
+	Budget   int    `yaml:"budget"`
+	OnExceed string `yaml:"on_exceed"`
+}
+
+// QuotaSpec describes a daily quota that resets at midnight Pacific time,
+// with CallType sorting requests into the types Units prices.
+type QuotaSpec struct {
+	Daily    int
+	Units    map[string]int
+	CallType func(method string, url string) string
+}
+
+var quotaSpecs = map[string]QuotaSpec{}
+
+var (
+	ledgers   = map[string]*QuotaLedger{}
+	ledgersMu sync.Mutex
+)
+
+func RegisterQuota(service string, spec QuotaSpec) {
+	quotaSpecs[service] = spec
+}
+
+// Quotas reset at midnight in Google's timezone
+var pacific = loadPacific()
+
+func loadPacific() *time.Location {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.FixedZone("PST", -8*60*60)
+	}
+	return location
+}
+
+func quotaDay(t time.Time) string {
+	return t.In(pacific).Format(time.DateOnly)
+}
+
+func nextQuotaReset(t time.Time) time.Time {
+	year, month, day := t.In(pacific).Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, pacific)
+}
+
+// QuotaLedger counts the units and calls of each type spent on a service
+// today. It is saved after every call so other runs see the same usage.
+type QuotaLedger struct {
+	Day       string         `json:"day"`
+	Units     map[string]int `json:"units"`
+	Calls     map[string]int `json:"calls"`
+	Exhausted bool           `json:"exhausted,omitempty"`
+
+	service string
+	spec    QuotaSpec
+	budget  int
+	wait    bool
+	path    string
+	clock   Clock
+	mu      sync.Mutex
+}
+
+func quotaLedgerPath(service string) string {
+	return filepath.Join(DataDir(), "quota", service+".json")
+}
+
+func newQuotaLedger(service string, config QuotaConfig) *QuotaLedger {
+	spec, ok := quotaSpecs[service]
+	if !ok {
+		return nil
+	}
+	ledger := &QuotaLedger{
+		service: service,
+		spec:    spec,
+		budget:  config.Budget,
+		wait:    config.OnExceed == quotaWait,
+		path:    quotaLedgerPath(service),
+		clock:   realClock{},
+	}
+	if ledger.budget <= 0 || ledger.budget > spec.Daily {
+		ledger.budget = spec.Daily
+	}
+	return ledger
+}
+
+// LoadQuotaLedger reads today's usage of service, returning nil when the
+// service has no quota.
+func LoadQuotaLedger(service string, config QuotaConfig) (*QuotaLedger, error) {
+	ledger := newQuotaLedger(service, config)
+	if ledger == nil {
+		return nil, nil
+	}
+	if err := ledger.load(); err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+// quotaLedgerFor returns the ledger shared by every client of service.
+func quotaLedgerFor(service string, config QuotaConfig) *QuotaLedger {
+	ledgersMu.Lock()
+	defer ledgersMu.Unlock()
+	if ledger, ok := ledgers[service]; ok {
+		return ledger
+	}
+	// A broken ledger starts counting from zero rather than failing requests
+	ledger := newQuotaLedger(service, config)
+	if ledger != nil {
+		if err := ledger.load(); err != nil {
+			fmt.Printf("Error loading quota ledger: %v\n", err)
+		}
+	}
+	ledgers[service] = ledger
+	return ledger
+}
+
+// quotaLedger returns the ledger of a loaded service, or nil when it has
+// no quota.
+func quotaLedger(service string) *QuotaLedger {
+	ledgersMu.Lock()
+	defer ledgersMu.Unlock()
+	return ledgers[service]
+}
+
+// load reads the saved usage, starting over when it is from another day.
+// On errors the usage counted so far is kept.
+func (l *QuotaLedger) load() error {
+	if today := quotaDay(l.clock.Now()); l.Day != today {
+		l.Day, l.Units, l.Calls, l.Exhausted = today, map[string]int{}, map[string]int{}, false
+	}
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading quota ledger: %v", err)
+	}
+	var saved QuotaLedger
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("error parsing quota ledger: %v", err)
+	}
+	if saved.Day == l.Day && saved.Units != nil && saved.Calls != nil {
+		l.Units, l.Calls, l.Exhausted = saved.Units, saved.Calls, saved.Exhausted
+	}
+	return nil
+}
+
+func (l *QuotaLedger) save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("error creating quota directory: %v", err)
+	}
+	data, err := json.MarshalIndent(l, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling quota ledger: %v", err)
+	}
+	if err := os.WriteFile(l.path, data, 0644); err != nil {
+		return fmt.Errorf("error writing quota ledger: %v", err)
+	}
+	return nil
+}
+
+func (l *QuotaLedger) used() int {
+	if l.Exhausted {
+		return l.budget
+	}
+	total := 0
+	for _, units := range l.Units {
+		total += units
+	}
+	return total
+}
+
+// Budget returns the units that may be used a day.
+func (l *QuotaLedger) Budget() int {
+	return l.budget
+}
+
+// Remaining returns the units left today.
+func (l *QuotaLedger) Remaining() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		fmt.Printf("Error loading quota ledger: %v\n", err)
+	}
+	return max(l.budget-l.used(), 0)
+}
+
+// Spend records a request before it is sent. When it would go over the
+// budget it fails with ErrQuota, or waits for the reset when configured to
+// unless ctx is cancelled first.
+func (l *QuotaLedger) Spend(ctx context.Context, method string, url string) error {
+	callType := l.spec.CallType(method, url)
+	units := l.spec.Units[callType]
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		// Pick up usage from other runs, and the reset at midnight
+		if err := l.load(); err != nil {
+			fmt.Printf("Error loading quota ledger: %v\n", err)
+		}
+		if l.used()+units <= l.budget {
+			break
+		}
+		reset := nextQuotaReset(l.clock.Now())
+		if !l.wait {
+			return fmt.Errorf("%w: %s budget of %d units is used up until %s", ErrQuota, l.service, l.budget, reset.Local().Format(time.DateTime))
+		}
+		fmt.Printf("%s quota budget is used up, pausing until %s\n", l.service, reset.Local().Format(time.DateTime))
+		l.mu.Unlock()
+		err := sleep(ctx, l.clock, reset.Sub(l.clock.Now())+time.Minute)
+		l.mu.Lock()
+		if err != nil {
+			return fmt.Errorf("%w: stopped waiting for the %s quota reset: %w", ErrQuota, l.service, err)
+		}
+	}
+
+	l.Units[callType] += units
+	l.Calls[callType]++
+	if err := l.save(); err != nil {
+		fmt.Printf("Error saving quota ledger: %v\n", err)
+	}
+	return nil
+}
+
+// Exhaust marks today's quota as used up after the service refused a
+// request for it, which happens when other apps share the quota.
+func (l *QuotaLedger) Exhaust() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		fmt.Printf("Error loading quota ledger: %v\n", err)
+	}
+	l.Exhausted = true
+	if err := l.save(); err != nil {
+		fmt.Printf("Error saving quota ledger: %v\n", err)
+	}
+}
+
+// QuotaEstimate counts the calls of each type an operation should make.
+type QuotaEstimate map[string]int
+
+// Cost prices an estimate in quota units.
+func (l *QuotaLedger) Cost(estimate QuotaEstimate) int {
+	total := 0
+	for callType, calls := range estimate {
+		total += calls * l.spec.Units[callType]
+	}
+	return total
+}
+
+// CheckQuota compares the estimated cost of an operation on service with
+// what is left of today's budget. Going over is refused unless on_exceed is
+// wait, in which case the operation pauses when the budget runs out and
+// carries on after the reset.
+func CheckQuota(service MusicService, action string, estimate QuotaEstimate) error {
+	ledger := quotaLedger(service.Name())
+	if ledger == nil {
+		return nil
+	}
+	cost := ledger.Cost(estimate)
+	remaining := ledger.Remaining()
+	fmt.Printf("Estimated %s quota for %s: %d units, %d of %d left today\n", service.DisplayName(), action, cost, remaining, ledger.Budget())
+	if cost <= remaining {
+		return nil
+	}
+
+	reset := nextQuotaReset(ledger.clock.Now()).Local().Format(time.DateTime)
+	if !ledger.wait && cost > ledger.Budget() {
+		return fmt.Errorf("%w: %s needs about %d %s units, more than the daily budget of %d, set quota.on_exceed to wait to spread it over several days",
+			ErrQuota, action, cost, service.DisplayName(), ledger.Budget())
+	}
+	if !ledger.wait {
+		return fmt.Errorf("%w: %s needs about %d %s units but %d are left today, run it again after %s or set quota.on_exceed to wait",
+			ErrQuota, action, cost, service.DisplayName(), remaining, reset)
+	}
+	days := 1 + (cost-remaining+ledger.Budget()-1)/ledger.Budget()
+	fmt.Printf("This will pause when the budget runs out and resume after the reset at %s, taking about %d days\n", reset, days)
+	return nil
+}
+
+// EstimateMatching estimates the searches needed to match the tracks of a
+// read playlist on the matcher's target. Writes are priced once the changes
+// to the target are known.
+func EstimateMatching(matcher *Matcher, playlist *Playlist) QuotaEstimate {
+	estimate := QuotaEstimate{}
+	if playlist.Service == matcher.Target.Name() {
+		return estimate
+	}
+	for _, track := range playlist.Tracks {
+		if matcher.Cache != nil {
+			if _, ok := matcher.Cache.Get(playlist.Service, track, matcher.Target.Name()); ok {
+				continue
+			}
+		}
+		// A search also looks up the durations of the results
+		estimate[quotaSearch]++
+		estimate[quotaRead]++
+	}
+	return estimate
+}
+
+// PrintQuota shows today's usage of service by call type.
+func PrintQuota(service string, ledger *QuotaLedger) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	if err := ledger.load(); err != nil {
+		fmt.Printf("Error loading quota ledger: %v\n", err)
+	}
+	now := ledger.clock.Now()
+	reset := nextQuotaReset(now)
+	fmt.Printf("%s: %d of %d units used today, resets at %s (in %s)\n",
+		service, ledger.used(), ledger.budget, reset.Local().Format(time.DateTime), reset.Sub(now).Round(time.Minute))
+
+	callTypes := make([]string, 0, len(ledger.Calls))
+	for callType := range ledger.Calls {
+		callTypes = append(callTypes, callType)
+	}
+	sort.Strings(callTypes)
+	for _, callType := range callTypes {
+		fmt.Printf("  %-8s %5d calls %7d units\n", callType, ledger.Calls[callType], ledger.Units[callType])
+	}
+	if ledger.Exhausted {
+		fmt.Printf("  %s reported the quota as exceeded\n", service)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuotaCommandConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		code   int
+	}{
+		{"missing", "", exitOK},
+		{"budget", "quota:\n  budget: 500\n", exitOK},
+		{"malformed", "quota: [budget\n", exitError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("XDG_DATA_HOME", dir)
+			path := filepath.Join(dir, "config.yml")
+			if test.config != "" {
+				if err := os.WriteFile(path, []byte(test.config), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if code := RunCommand("quota", []string{"-config", path, "youtube"}); code != test.code {
+				t.Errorf("quota exited with %d, want %d", code, test.code)
+			}
+		})
+	}
+}
+
+// One minute before the quota resets at midnight Pacific time
+var beforeReset = time.Date(2025, 3, 1, 23, 59, 0, 0, pacific)
+
+// newTestLedger returns a ledger of a "test" service where searches cost
+// 100 units and reads 1, running on clock. Callers set XDG_DATA_HOME.
+func newTestLedger(t *testing.T, config QuotaConfig, clock Clock) *QuotaLedger {
+	t.Helper()
+	RegisterQuota("test", QuotaSpec{
+		Daily:    1000,
+		Units:    map[string]int{quotaSearch: 100, quotaRead: 1},
+		CallType: func(method string, url string) string { return url },
+	})
+	t.Cleanup(func() { delete(quotaSpecs, "test") })
+	ledger := newQuotaLedger("test", config)
+	ledger.clock = clock
+	if err := ledger.load(); err != nil {
+		t.Fatal(err)
+	}
+	return ledger
+}
+
+func TestQuotaLedgerReset(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	clock := &fakeClock{now: beforeReset}
+	ledger := newTestLedger(t, QuotaConfig{}, clock)
+	if err := ledger.Spend(context.Background(), "GET", quotaSearch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another run reads the saved usage, and sees what this one spends
+	other := newTestLedger(t, QuotaConfig{}, clock)
+	if remaining := other.Remaining(); remaining != 900 {
+		t.Errorf("other run has %d units left, want 900", remaining)
+	}
+	if err := ledger.Spend(context.Background(), "GET", quotaRead); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := other.Remaining(); remaining != 899 {
+		t.Errorf("other run has %d units left, want 899", remaining)
+	}
+
+	// Both start over after midnight Pacific time
+	clock.After(2 * time.Minute)
+	for _, l := range []*QuotaLedger{ledger, other} {
+		if remaining := l.Remaining(); remaining != 1000 {
+			t.Errorf("%d units left after the reset, want 1000", remaining)
+		}
+		if l.Day != "2025-03-02" || len(l.Calls) != 0 {
+			t.Errorf("ledger is for %s with %v calls after the reset, want 2025-03-02 with none", l.Day, l.Calls)
+		}
+	}
+}
+
+func TestQuotaLedgerSpend(t *testing.T) {
+	tests := []struct {
+		name     string
+		onExceed string
+		exhaust  bool
+		spent    int
+		waited   bool
+	}{
+		// Two searches fit the budget of 250, the third doesn't
+		{"refuse", "", false, 2, false},
+		{"wait", quotaWait, false, 1, true},
+
+		// The service said the quota is used up, whatever was counted
+		{"exhausted refuse", "", true, 0, false},
+		{"exhausted wait", quotaWait, true, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("XDG_DATA_HOME", t.TempDir())
+			clock := &fakeClock{now: beforeReset}
+			ledger := newTestLedger(t, QuotaConfig{Budget: 250, OnExceed: test.onExceed}, clock)
+			ctx := context.Background()
+
+			searches := 3
+			if test.exhaust {
+				ledger.Exhaust()
+				if remaining := ledger.Remaining(); remaining != 0 {
+					t.Errorf("%d units left after the quota was exhausted, want 0", remaining)
+				}
+				searches = 1
+			}
+			var err error
+			for i := 0; i < searches && err == nil; i++ {
+				err = ledger.Spend(ctx, "GET", quotaSearch)
+			}
+
+			if test.waited {
+				if err != nil {
+					t.Fatalf("Spend() = %v, want it to wait for the reset", err)
+				}
+				if !clock.Now().After(nextQuotaReset(beforeReset)) {
+					t.Errorf("clock at %s, want past the reset", clock.Now())
+				}
+				if ledger.Exhausted {
+					t.Error("ledger still exhausted after the reset")
+				}
+			} else if !errors.Is(err, ErrQuota) {
+				t.Fatalf("Spend() = %v, want a quota error", err)
+			}
+			if spent := ledger.Calls[quotaSearch]; spent != test.spent {
+				t.Errorf("%d searches counted, want %d", spent, test.spent)
+			}
+		})
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		onExceed string
+		searches int
+		ok       bool
+	}{
+		{"fits", "", 2, true},
+		{"over what is left", "", 3, false},
+		{"over what is left, wait", quotaWait, 3, true},
+		{"over the budget", "", 6, false},
+		{"over the budget, wait", quotaWait, 6, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("XDG_DATA_HOME", t.TempDir())
+			ledger := newTestLedger(t, QuotaConfig{Budget: 300, OnExceed: test.onExceed}, &fakeClock{now: beforeReset})
+			if err := ledger.Spend(context.Background(), "GET", quotaSearch); err != nil {
+				t.Fatal(err)
+			}
+			ledgers["test"] = ledger
+			t.Cleanup(func() { delete(ledgers, "test") })
+
+			err := CheckQuota(newFakeService("test"), "searching", QuotaEstimate{quotaSearch: test.searches})
+			if test.ok && err != nil {
+				t.Errorf("CheckQuota() = %v, want it allowed", err)
+			}
+			if !test.ok && !errors.Is(err, ErrQuota) {
+				t.Errorf("CheckQuota() = %v, want a quota error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TransferReport records what happened to every source track of a
+// transfer. It is saved next to the cached playlist as JSON and Markdown.
+type TransferReport struct {
+	SourceService string        `json:"source_service"`
+	SourceID      string        `json:"source_id"`
+	SourceName    string        `json:"source_name"`
+	TargetService string        `json:"target_service"`
+	TargetID      string        `json:"target_id"`
+	Mode          string        `json:"mode"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Matched       []ReportTrack `json:"matched"`
+	Unmatched     []ReportTrack `json:"unmatched"`
+	Failed        []ReportTrack `json:"failed"`
+	Duplicates    []ReportTrack `json:"duplicates"`
+}
+
+type ReportTrack struct {
+	// Position in the source playlist, starting at 1
+	Position int     `json:"position"`
+	Name     string  `json:"name"`
+	Artist   string  `json:"artist"`
+	SourceID string  `json:"source_id"`
+	TargetID string  `json:"target_id,omitempty"`
+	Score    float64 `json:"score,omitempty"`
+	Reason   string  `json:"reason,omitempty"`
+}
+
+// reportFilePath returns where the report for a cached playlist file is
+// written, ext being ".json" or ".md".
+func reportFilePath(file string, ext string) string {
+	return strings.TrimSuffix(file, ".json") + ".report" + ext
+}
+
+// NewTransferReport sorts the tracks of a matched playlist file into
+// matched and unmatched tracks. Duplicates are added like any other match,
+// so they are listed as matched and again under Duplicates. Failed adds are
+// recorded with AddResults.
+func NewTransferReport(file string, targetID string, mode string) (*TransferReport, error) {
+	playlist, err := LoadPlaylistFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "" {
+		mode = modeReplace
+	}
+	report := &TransferReport{
+		SourceService: playlist.Service,
+		SourceID:      playlist.ID,
+		SourceName:    playlist.Name,
+		TargetService: playlist.TargetService,
+		TargetID:      targetID,
+		Mode:          mode,
+		CreatedAt:     time.Now(),
+		Matched:       []ReportTrack{},
+		Unmatched:     []ReportTrack{},
+		Failed:        []ReportTrack{},
+		Duplicates:    []ReportTrack{},
+	}
+
+	first := map[string]int{}
+	for i, track := range playlist.Tracks {
+		entry := ReportTrack{
+			Position: i + 1,
+			Name:     track.Name,
+			Artist:   track.Artist(),
+			SourceID: track.ID,
+			TargetID: track.TargetID,
+			Score:    track.MatchScore,
+		}
+		switch {
+		case track.TargetID == "" && track.MatchScore > 0:
+			entry.Reason = fmt.Sprintf("best candidate scored %s, below the match threshold", formatScore(track.MatchScore))
+			report.Unmatched = append(report.Unmatched, entry)
+		case track.TargetID == "":
+			entry.Reason = "no candidates found"
+			report.Unmatched = append(report.Unmatched, entry)
+		case first[track.TargetID] > 0:
+			report.Matched = append(report.Matched, entry)
+			entry.Reason = fmt.Sprintf("same target track as position %d", first[track.TargetID])
+			report.Duplicates = append(report.Duplicates, entry)
+		default:
+			first[track.TargetID] = i + 1
+			report.Matched = append(report.Matched, entry)
+		}
+	}
+	return report, nil
+}
+
+// AddResults moves matched tracks that were in failed batches to Failed,
+// finding them by their source position.
+func (r *TransferReport) AddResults(results []BatchResult) {
+	for _, batch := range results {
+		if batch.Err == nil {
+			continue
+		}
+		for _, track := range batch.Tracks {
+			for i, entry := range r.Matched {
+				if entry.Position == track.Source+1 {
+					entry.Reason = batch.Err.Error()
+					r.Failed = append(r.Failed, entry)
+					r.Matched = append(r.Matched[:i], r.Matched[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Save writes the report next to the cached playlist file.
+func (r *TransferReport) Save(file string) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling report: %v", err)
+	}
+	if err := os.WriteFile(reportFilePath(file, ".json"), data, 0644); err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+	path := reportFilePath(file, ".md")
+	if err := os.WriteFile(path, []byte(r.Markdown()), 0644); err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+	fmt.Printf("Wrote transfer report to %s\n", path)
+	return nil
+}
+
+func (r *TransferReport) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Transfer report: %s\n\n", r.SourceName)
+	fmt.Fprintf(&b, "- Source: %s:%s\n", r.SourceService, r.SourceID)
+	fmt.Fprintf(&b, "- Target: %s:%s\n", r.TargetService, r.TargetID)
+	fmt.Fprintf(&b, "- Mode: %s\n", r.Mode)
+	fmt.Fprintf(&b, "- Date: %s\n", r.CreatedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(&b, "- Matched: %d (%d duplicates), unmatched: %d, failed: %d\n",
+		len(r.Matched), len(r.Duplicates), len(r.Unmatched), len(r.Failed))
+
+	sections := []struct {
+		title  string
+		tracks []ReportTrack
+	}{
+		{"Unmatched", r.Unmatched},
+		{"Failed", r.Failed},
+		{"Duplicates", r.Duplicates},
+	}
+	for _, section := range sections {
+		if len(section.tracks) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s (%d)\n\n| # | Track | Artist | Reason |\n|---|---|---|---|\n", section.title, len(section.tracks))
+		for _, track := range section.tracks {
+			fmt.Fprintf(&b, "| %d | %s | %s | %s |\n", track.Position, markdownCell(track.Name), markdownCell(track.Artist), markdownCell(track.Reason))
+		}
+	}
+
+	if len(r.Matched) > 0 {
+		fmt.Fprintf(&b, "\n## Matched (%d)\n\n| # | Track | Artist | Target ID | Score |\n|---|---|---|---|---|\n", len(r.Matched))
+		for _, track := range r.Matched {
+			fmt.Fprintf(&b, "| %d | %s | %s | %s | %s |\n", track.Position, markdownCell(track.Name), markdownCell(track.Artist), track.TargetID, formatScore(track.Score))
+		}
+	}
+	return b.String()
+}
+
+func markdownCell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
+
+// WriteTransferReport builds and saves the report of a finished transfer,
+// printing rather than returning errors so the transfer result stands.
+func WriteTransferReport(file string, targetID string, mode string, results []BatchResult) {
+	report, err := NewTransferReport(file, targetID, mode)
+	if err != nil {
+		fmt.Printf("Error building transfer report: %v\n", err)
+		return
+	}
+	report.AddResults(results)
+	if err := report.Save(file); err != nil {
+		fmt.Printf("Error saving transfer report: %v\n", err)
+	}
+}
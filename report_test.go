@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransferReportFailures(t *testing.T) {
+	// Exit Music was not matched, so target tracks and source positions
+	// are out of step from there on
+	source := &Playlist{Service: "spotify", ID: "src", Name: "Radiohead", TargetService: "youtube"}
+	for _, song := range []string{"a", "e", "b", "c", "d", "f"} {
+		track := Track{ID: "sp-" + song, Name: twoWaySongs[song], Artists: []string{"Radiohead"}, TargetID: "yt-" + song, MatchScore: 0.9}
+		if song == "e" {
+			track.TargetID, track.MatchScore = "", 0.4
+		}
+		source.Tracks = append(source.Tracks, track)
+	}
+	file := filepath.Join(t.TempDir(), "src.json")
+	if err := SavePlaylistFile(file, source); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch with Bones and Creep, third and fourth on the source, failed
+	tracks := source.TargetTracks()
+	results := []BatchResult{
+		{Position: -1, Tracks: tracks[:1]},
+		{Position: -1, Tracks: tracks[1:3], Err: errors.New("playlist is full")},
+		{Position: -1, Tracks: tracks[3:]},
+	}
+	WriteTransferReport(file, "dst", modeReplace, results)
+
+	data, err := os.ReadFile(reportFilePath(file, ".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report TransferReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	positions := func(tracks []ReportTrack) map[int]string {
+		result := map[int]string{}
+		for _, track := range tracks {
+			result[track.Position] = track.Name
+		}
+		return result
+	}
+	failed, matched := positions(report.Failed), positions(report.Matched)
+	if len(failed) != 2 || failed[3] != "Bones" || failed[4] != "Creep" {
+		t.Errorf("failed tracks are %v, want Bones at 3 and Creep at 4", failed)
+	}
+	if len(matched) != 3 || matched[1] != "Airbag" || matched[5] != "Daydreaming" || matched[6] != "Fake Plastic Trees" {
+		t.Errorf("matched tracks are %v, want Airbag at 1, Daydreaming at 5 and Fake Plastic Trees at 6", matched)
+	}
+	if unmatched := positions(report.Unmatched); len(unmatched) != 1 || unmatched[2] != "Exit Music" {
+		t.Errorf("unmatched tracks are %v, want Exit Music at 2", unmatched)
+	}
+	for _, track := range report.Failed {
+		if track.Reason != "playlist is full" {
+			t.Errorf("%s failed with %q, want the batch error", track.Name, track.Reason)
+		}
+	}
+
+	data, err = os.ReadFile(reportFilePath(file, ".md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	markdown := string(data)
+	_, failedSection, found := strings.Cut(markdown, "## Failed (2)")
+	if !found {
+		t.Fatalf("no failed section in report:\n%s", markdown)
+	}
+	failedSection, _, _ = strings.Cut(failedSection, "\n## ")
+	for _, row := range []string{"| 3 | Bones | Radiohead | playlist is full |", "| 4 | Creep | Radiohead | playlist is full |"} {
+		if !strings.Contains(failedSection, row) {
+			t.Errorf("failed section is missing %q:\n%s", row, failedSection)
+		}
+	}
+	if !strings.Contains(markdown, "- Matched: 3 (0 duplicates), unmatched: 1, failed: 2") {
+		t.Errorf("report summary is wrong:\n%s", markdown)
+	}
+}
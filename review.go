@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// reviewQueue returns the indexes of tracks that were not matched or were
+// matched below the review threshold.
+func reviewQueue(playlist *Playlist, matcher *Matcher) []int {
+	var queue []int
+	for i, track := range playlist.Tracks {
+		if playlist.Service == matcher.Target.Name() {
+			continue
+		}
+		if matcher.needsReview(track) {
+			queue = append(queue, i)
+		}
+	}
+	return queue
+}
+
+// CountReview returns how many tracks in file ReviewMatches would ask about.
+func CountReview(matcher *Matcher, file string) (int, error) {
+	playlist, err := LoadPlaylistFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return len(reviewQueue(playlist, matcher)), nil
+}
+
+// ReviewMatches walks through unmatched and low confidence tracks in file,
+// showing the best candidates kept from matching for each. The user picks
+// one, searches again, pastes a link or ID, or skips. Choices are saved to
+// the match cache as manual overrides.
+func ReviewMatches(ctx context.Context, matcher *Matcher, file string, in *bufio.Reader) error {
+	playlist, err := LoadPlaylistFile(file)
+	if err != nil {
+		return err
+	}
+	queue := reviewQueue(playlist, matcher)
+	if len(queue) == 0 {
+		fmt.Println("Nothing to review")
+		return nil
+	}
+
+	// Tracks matched from the cache have no candidates and are searched
+	// again, if the quota allows
+	target := matcher.Target
+	searches := 0
+	for _, i := range queue {
+		if len(playlist.Tracks[i].Candidates) == 0 {
+			searches++
+		}
+	}
+	search := true
+	if searches > 0 {
+		if err := CheckQuota(target, "searching for candidates", QuotaEstimate{quotaSearch: searches}); err != nil {
+			fmt.Printf("Reviewing without searching: %v\n", err)
+			search = false
+		}
+	}
+
+	changed := 0
+	for n, i := range queue {
+		track := &playlist.Tracks[i]
+		fmt.Printf("\n[%d/%d] %s by %s", n+1, len(queue), track.Name, track.Artist())
+		if track.TargetID != "" {
+			fmt.Printf(" (matched %s at %s)", track.TargetID, formatScore(track.MatchScore))
+		}
+		fmt.Println()
+
+		candidates := track.Candidates
+		if len(candidates) == 0 && search {
+			if candidates, err = matcher.Rank(ctx, *track); err != nil {
+				fmt.Printf("Error searching for %s: %v\n", track.Name, err)
+			}
+		}
+
+		for {
+			printCandidates(candidates)
+			fmt.Printf("Choose 1-%d, q <query> to search again, paste a %s link or ID, or Enter to skip: ", len(candidates), target.DisplayName())
+			line, err := in.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if line == "" {
+				if err == io.EOF {
+					fmt.Println()
+					return finishReview(matcher, file, playlist, changed)
+				}
+				break
+			}
+
+			// New search, scored against the original track
+			if query, found := strings.CutPrefix(line, "q "); found {
+				results, err := target.SearchTracks(ctx, Track{Name: strings.TrimSpace(query)}, matcher.Candidates)
+				if err != nil {
+					fmt.Printf("Error searching: %v\n", err)
+					continue
+				}
+				candidates = nil
+				for _, result := range results {
+					candidates = append(candidates, ScoredTrack{Track: result, Score: ScoreMatch(*track, result)})
+				}
+				continue
+			}
+
+			var id string
+			if number, err := strconv.Atoi(line); err == nil {
+				if number < 1 || number > len(candidates) {
+					fmt.Println("Invalid choice")
+					continue
+				}
+				id = candidates[number-1].Track.ID
+			} else if linked, ok := target.ParseTrackID(line); ok {
+				id = linked
+			} else {
+				fmt.Println("Not a number, search or link")
+				continue
+			}
+
+			// Chosen by hand, so it is not offered for review again
+			track.TargetID = id
+			track.MatchScore = 1
+			track.Candidates = nil
+			if matcher.Cache != nil {
+				matcher.Cache.Put(playlist.Service, *track, target.Name(), CachedMatch{TargetID: id, Score: 1, Manual: true})
+			}
+			changed++
+			fmt.Printf("Matched %s by %s -> %s\n", track.Name, track.Artist(), id)
+			break
+		}
+	}
+	return finishReview(matcher, file, playlist, changed)
+}
+
+func printCandidates(candidates []ScoredTrack) {
+	if len(candidates) == 0 {
+		fmt.Println("  No candidates found")
+	}
+	for i, candidate := range candidates {
+		fmt.Printf("  %d. [%s] %s by %s (ID: %s)\n", i+1, formatScore(candidate.Score), candidate.Track.Name, candidate.Track.Artist(), candidate.Track.ID)
+	}
+}
+
+func finishReview(matcher *Matcher, file string, playlist *Playlist, changed int) error {
+	fmt.Printf("Reviewed tracks, %d matches changed\n", changed)
+	if matcher.Cache != nil {
+		if err := matcher.Cache.Save(); err != nil {
+			fmt.Printf("Error saving match cache: %v\n", err)
+		}
+	}
+	return SavePlaylistFile(file, playlist)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// MusicService is implemented by every streaming provider playlistty can
+// read from or write to.
+type MusicService interface {
+	Name() string
+	DisplayName() string
+	ValidateAuth() error
+	ListPlaylists() ([]PlaylistSummary, error)
+	ReadPlaylist(playlist string) (*Playlist, error)
+	// SearchTracks stops when ctx is cancelled, even while waiting out a
+	// rate limit or the quota
+	SearchTracks(ctx context.Context, track Track, limit int) ([]Track, error)
+	CreatePlaylist(title string, description string, public bool) (string, error)
+	ClearPlaylist(playlist string) error
+	AddTracks(playlist string, position int, tracks []Track) []BatchResult
+	// BatchSize is the most tracks AddTracks sends in one request
+	BatchSize() int
+	// RemoveTracks removes tracks as read by ReadPlaylist, only the copies
+	// given when a track is on the playlist more than once
+	RemoveTracks(playlist string, tracks []Track) error
+	MoveTrack(playlist string, track Track, from int, to int) error
+	// ParseTrackID reads a track ID from a link, URI or bare ID pasted by
+	// the user
+	ParseTrackID(input string) (string, bool)
+}
+
+type PlaylistSummary struct {
+	ID   string
+	Name string
+}
+
+// BatchResult records the outcome of a single insert request. Position is
+// the playlist index the batch was inserted at, or -1 when appended.
+type BatchResult struct {
+	Position int
+	Tracks   []Track
+	Err      error
+}
+
+type ServiceFactory func(config *Config) MusicService
+
+var registry = map[string]ServiceFactory{}
+
+// Short names accepted on the command line
+var serviceAliases = map[string]string{
+	"yt": "youtube",
+}
+
+func RegisterService(name string, factory ServiceFactory) {
+	registry[name] = factory
+}
+
+func ServiceNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func serviceName(name string) string {
+	if alias, ok := serviceAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func GetService(name string, configPath string) (MusicService, error) {
+	factory, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported service: %s", name)
+	}
+
+	// Parse config file
+	config, err := ParseConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return factory(config), nil
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const spotifyAPI = "https://api.spotify.com/v1"
+
+// Maximum number of URIs Spotify accepts per add or remove request
+const spotifyBatchSize = 100
+
+// Track IDs are 22 base62 characters
+var spotifyID = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+type SpotifyService struct {
+	config *Config
+	api    *APIClient
+}
+
+func init() {
+	RegisterService("spotify", NewSpotifyService)
+	RegisterOAuth("spotify", spotifyOAuth)
+	RegisterRateLimit("spotify", 10)
+}
+
+func spotifyOAuth(config *Config) (*oauth2.Config, *OAuthToken) {
+	oauthConfig := &oauth2.Config{
+		ClientID:     config.Spotify.ClientID,
+		ClientSecret: config.Spotify.ClientSecret,
+		RedirectURL:  oauthRedirectURL,
+		Scopes: []string{
+			"playlist-modify-public",
+			"playlist-modify-private",
+			"playlist-read-private",
+			"playlist-read-collaborative",
+		},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://accounts.spotify.com/authorize",
+			TokenURL: "https://accounts.spotify.com/api/token",
+		},
+	}
+	return oauthConfig, &config.Spotify.OAuthToken
+}
+
+func NewSpotifyService(config *Config) MusicService {
+	return &SpotifyService{config: config, api: NewAPIClient("spotify", config, NewOAuthClient("spotify", config))}
+}
+
+func (s *SpotifyService) Name() string {
+	return "spotify"
+}
+
+func (s *SpotifyService) DisplayName() string {
+	return "Spotify"
+}
+
+// do sends a request that runs to completion, so a cancelled transfer
+// finishes the write in progress. Searches take a context instead.
+func (s *SpotifyService) do(method string, url string, body interface{}) (*http.Response, error) {
+	return s.api.Do(context.Background(), method, url, body)
+}
+
+func (s *SpotifyService) ValidateAuth() error {
+	if s.config.Spotify.OAuthToken.Empty() {
+		return s.reauthorize()
+	}
+
+	// Test token by making a request
+	resp, err := s.do("GET", spotifyAPI+"/me", nil)
+	if err != nil {
+		if isAuthError(err) {
+			return s.reauthorize()
+		}
+		return err
+	}
+	resp.Body.Close()
+
+	fmt.Println("Token is valid")
+	return nil
+}
+
+func (s *SpotifyService) reauthorize() error {
+	config, client, err := reauthorize("spotify", s.config.path)
+	if err != nil {
+		return err
+	}
+	s.config = config
+	s.api = NewAPIClient("spotify", config, client)
+	return nil
+}
+
+func (s *SpotifyService) ListPlaylists() ([]PlaylistSummary, error) {
+	var summaries []PlaylistSummary
+
+	// Create request URL for Spotify playlists endpoint
+	next := fmt.Sprintf("%s/users/%s/playlists?limit=50", spotifyAPI, s.config.Spotify.UserID)
+	for next != "" {
+		resp, err := s.do("GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse response
+		var page struct {
+			Next  string `json:"next"`
+			Items []struct {
+				Name string `json:"name"`
+				ID   string `json:"id"`
+			} `json:"items"`
+		}
+		if err := decodeResponse(resp, &page); err != nil {
+			return nil, err
+		}
+
+		for _, playlist := range page.Items {
+			summaries = append(summaries, PlaylistSummary{ID: playlist.ID, Name: playlist.Name})
+		}
+		next = page.Next
+	}
+	return summaries, nil
+}
+
+type spotifyTrack struct {
+	ID         string `json:"id"`
+	URI        string `json:"uri"`
+	Name       string `json:"name"`
+	DurationMs int    `json:"duration_ms"`
+	Explicit   bool   `json:"explicit"`
+	Artists    []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		Name        string `json:"name"`
+		ReleaseDate string `json:"release_date"`
+	} `json:"album"`
+	ExternalIDs struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+
+	// Index on the playlist, counting skipped items
+	position int
+}
+
+func (t spotifyTrack) toTrack() Track {
+	track := Track{
+		ID:         t.ID,
+		URI:        t.URI,
+		Name:       t.Name,
+		Album:      t.Album.Name,
+		DurationMs: t.DurationMs,
+		ISRC:       t.ExternalIDs.ISRC,
+		Explicit:   t.Explicit,
+		Position:   t.position,
+	}
+	for _, artist := range t.Artists {
+		track.Artists = append(track.Artists, artist.Name)
+	}
+	// Release dates are YYYY, YYYY-MM or YYYY-MM-DD depending on precision
+	if len(t.Album.ReleaseDate) >= 4 {
+		track.ReleaseYear, _ = strconv.Atoi(t.Album.ReleaseDate[:4])
+	}
+	return track
+}
+
+// playlistItems follows the paging links of a playlist's tracks endpoint,
+// skipping removed or unavailable items.
+func (s *SpotifyService) playlistItems(playlist string) ([]spotifyTrack, error) {
+	var tracks []spotifyTrack
+	position := 0
+
+	next := fmt.Sprintf("%s/playlists/%s/tracks?limit=100", spotifyAPI, playlist)
+	for next != "" {
+		resp, err := s.do("GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Next  string `json:"next"`
+			Items []struct {
+				Track *spotifyTrack `json:"track"`
+			} `json:"items"`
+		}
+		if err := decodeResponse(resp, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			position++
+			if item.Track == nil {
+				continue
+			}
+			item.Track.position = position - 1
+			tracks = append(tracks, *item.Track)
+		}
+		next = page.Next
+	}
+	return tracks, nil
+}
+
+func (s *SpotifyService) ReadPlaylist(playlist string) (*Playlist, error) {
+	// Get playlist name
+	resp, err := s.do("GET", fmt.Sprintf("%s/playlists/%s?fields=name", spotifyAPI, playlist), nil)
+	if err != nil {
+		return nil, err
+	}
+	var playlistData struct {
+		Name string `json:"name"`
+	}
+	if err := decodeResponse(resp, &playlistData); err != nil {
+		return nil, err
+	}
+
+	// Get tracks
+	items, err := s.playlistItems(playlist)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract tracks into playlist
+	result := &Playlist{ID: playlist, Name: playlistData.Name}
+	for _, item := range items {
+		result.Tracks = append(result.Tracks, item.toTrack())
+	}
+	return result, nil
+}
+
+func (s *SpotifyService) SearchTracks(ctx context.Context, track Track, limit int) ([]Track, error) {
+	// An ISRC identifies the exact recording, try it before text search
+	var queries []string
+	if track.ISRC != "" {
+		queries = append(queries, "isrc:"+track.ISRC)
+	}
+
+	// Field filters are precise but miss on small spelling differences, so
+	// fall back to a plain query when they find nothing
+	queries = append(queries,
+		fmt.Sprintf("track:%s artist:%s", track.Name, track.Artist()),
+		fmt.Sprintf("%s %s", track.Name, track.Artist()),
+	)
+	for _, q := range queries {
+		tracks, err := s.search(ctx, q, limit)
+		if err != nil || len(tracks) > 0 {
+			return tracks, err
+		}
+	}
+	return nil, nil
+}
+
+func (s *SpotifyService) search(ctx context.Context, q string, limit int) ([]Track, error) {
+	// Create search query
+	query := url.Values{}
+	query.Set("q", q)
+	query.Set("type", "track")
+	query.Set("limit", strconv.Itoa(limit))
+
+	resp, err := s.api.Do(ctx, "GET", spotifyAPI+"/search?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	var result struct {
+		Tracks struct {
+			Items []spotifyTrack `json:"items"`
+		} `json:"tracks"`
+	}
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	tracks := make([]Track, len(result.Tracks.Items))
+	for i, item := range result.Tracks.Items {
+		tracks[i] = item.toTrack()
+	}
+	return tracks, nil
+}
+
+func (s *SpotifyService) CreatePlaylist(title string, description string, public bool) (string, error) {
+	// Create request URL for Spotify playlists endpoint
+	url := fmt.Sprintf("%s/users/%s/playlists", spotifyAPI, s.config.Spotify.UserID)
+
+	requestBody := map[string]interface{}{
+		"name":        title,
+		"description": description,
+		"public":      public,
+	}
+	resp, err := s.do("POST", url, requestBody)
+	if err != nil {
+		return "", fmt.Errorf("error creating playlist: %w", err)
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := decodeResponse(resp, &created); err != nil {
+		return "", err
+	}
+	fmt.Printf("Successfully created playlist %s\n", title)
+	return created.ID, nil
+}
+
+func (s *SpotifyService) ClearPlaylist(playlist string) error {
+	// Get all tracks in playlist first
+	items, err := s.playlistItems(playlist)
+	if err != nil {
+		return err
+	}
+	tracks := make([]Track, len(items))
+	for i, item := range items {
+		tracks[i] = item.toTrack()
+	}
+
+	if err := s.RemoveTracks(playlist, tracks); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully cleared playlist\n")
+	return nil
+}
+
+func (s *SpotifyService) AddTracks(playlist string, position int, tracks []Track) []BatchResult {
+	url := fmt.Sprintf("%s/playlists/%s/tracks", spotifyAPI, playlist)
+
+	// Add tracks in batches of up to 100
+	var results []BatchResult
+	for i := 0; i < len(tracks); i += spotifyBatchSize {
+		end := i + spotifyBatchSize
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		batch := BatchResult{Position: position, Tracks: tracks[i:end]}
+
+		uris := make([]string, len(batch.Tracks))
+		for j, track := range batch.Tracks {
+			uris[j] = fmt.Sprintf("spotify:track:%s", track.ID)
+		}
+		requestBody := map[string]interface{}{"uris": uris}
+		if position >= 0 {
+			requestBody["position"] = position
+		}
+
+		resp, err := s.do("POST", url, requestBody)
+		if err != nil {
+			batch.Err = fmt.Errorf("error adding tracks: %w", err)
+		} else {
+			resp.Body.Close()
+		}
+
+		// Only advance the insert position past tracks that were added
+		if batch.Err == nil && position >= 0 {
+			position += len(batch.Tracks)
+		}
+		results = append(results, batch)
+	}
+	return results
+}
+
+func (s *SpotifyService) BatchSize() int {
+	return spotifyBatchSize
+}
+
+func (s *SpotifyService) RemoveTracks(playlist string, tracks []Track) error {
+	// Deleting by URI alone removes every copy, so each track is deleted at
+	// its position. Going from the end keeps the positions of the batches
+	// still to come.
+	sorted := make([]Track, len(tracks))
+	copy(sorted, tracks)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Position > sorted[j].Position
+	})
+
+	tracksURL := fmt.Sprintf("%s/playlists/%s/tracks", spotifyAPI, playlist)
+	for i := 0; i < len(sorted); i += spotifyBatchSize {
+		end := i + spotifyBatchSize
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+
+		// Create array of track URIs with the positions to delete
+		var uris []string
+		positions := map[string][]int{}
+		for _, track := range sorted[i:end] {
+			uri := track.URI
+			if uri == "" {
+				uri = "spotify:track:" + track.ID
+			}
+			if _, found := positions[uri]; !found {
+				uris = append(uris, uri)
+			}
+			positions[uri] = append(positions[uri], track.Position)
+		}
+		var trackList []map[string]interface{}
+		for _, uri := range uris {
+			trackList = append(trackList, map[string]interface{}{
+				"uri":       uri,
+				"positions": positions[uri],
+			})
+		}
+
+		resp, err := s.do("DELETE", tracksURL, map[string]interface{}{"tracks": trackList})
+		if err != nil {
+			return fmt.Errorf("error removing tracks: %w", err)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+func (s *SpotifyService) MoveTrack(playlist string, track Track, from int, to int) error {
+	// insert_before refers to positions before the move
+	insertBefore := to
+	if to > from {
+		insertBefore = to + 1
+	}
+	requestBody := map[string]interface{}{
+		"range_start":   from,
+		"insert_before": insertBefore,
+		"range_length":  1,
+	}
+	resp, err := s.do("PUT", fmt.Sprintf("%s/playlists/%s/tracks", spotifyAPI, playlist), requestBody)
+	if err != nil {
+		return fmt.Errorf("error moving track %s: %w", track.Name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ParseTrackID reads a track ID from an open.spotify.com link, a
+// spotify:track: URI or a bare ID.
+func (s *SpotifyService) ParseTrackID(input string) (string, bool) {
+	input = strings.TrimSpace(input)
+	if id, found := strings.CutPrefix(input, "spotify:track:"); found {
+		input = id
+	} else if link, err := url.Parse(input); err == nil && strings.HasSuffix(link.Host, "spotify.com") {
+		parts := strings.Split(strings.Trim(link.Path, "/"), "/")
+		if len(parts) < 2 || parts[len(parts)-2] != "track" {
+			return "", false
+		}
+		input = parts[len(parts)-1]
+	}
+	return input, spotifyID.MatchString(input)
+}
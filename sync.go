@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Ways a transfer can update an existing target playlist
+const (
+	modeReplace = "replace"
+	modeSync    = "sync"
+	modeTwoWay  = "twoway"
+)
+
+// PlaylistDiff is the set of changes that turns the current contents of a
+// playlist into the desired ones, compared by service track ID.
+type PlaylistDiff struct {
+	Remove []Track
+	Add    []Track
+}
+
+type TrackMove struct {
+	Track Track `json:"track"`
+	From  int   `json:"from"`
+	To    int   `json:"to"`
+}
+
+func validMode(mode string) bool {
+	return mode == modeReplace || mode == modeSync
+}
+
+// DiffPlaylist compares current and desired by track ID. Tracks missing
+// from desired, and copies beyond the number desired has, are removed.
+// Desired tracks missing from current (or appearing more often than in
+// current) are appended. Tracks already on the playlist are left in place
+// so they keep their added-at dates, as are tracks without an ID such as
+// Spotify local files, which can't be added back.
+func DiffPlaylist(current []Track, desired []Track) PlaylistDiff {
+	diff := PlaylistDiff{Remove: []Track{}, Add: []Track{}}
+
+	wanted := map[string]int{}
+	for _, track := range desired {
+		wanted[track.ID]++
+	}
+
+	// The first copies are kept, later ones are surplus
+	have := map[string]int{}
+	for _, track := range current {
+		if track.ID == "" {
+			continue
+		}
+		if have[track.ID] >= wanted[track.ID] {
+			diff.Remove = append(diff.Remove, track)
+			continue
+		}
+		have[track.ID]++
+	}
+
+	for _, track := range desired {
+		if track.ID == "" {
+			continue
+		}
+		if have[track.ID] > 0 {
+			have[track.ID]--
+			continue
+		}
+		diff.Add = append(diff.Add, track)
+	}
+	return diff
+}
+
+// PlanMoves returns the single track moves that bring current into the
+// order of desired, applied one after another. Tracks on current that
+// aren't in desired end up after the desired ones, desired tracks that
+// aren't on current are left out. Tracks without an ID are skipped over
+// and keep their place between the others.
+func PlanMoves(current []Track, desired []Track) []TrackMove {
+	order := make([]Track, len(current))
+	copy(order, current)
+
+	var moves []TrackMove
+	next := 0
+	for _, track := range desired {
+		if track.ID == "" {
+			continue
+		}
+		i := next
+		for i < len(order) && order[i].ID == "" {
+			i++
+		}
+		if i >= len(order) {
+			break
+		}
+
+		// A track that isn't on the playlist, such as one the service
+		// refused, leaves the slot to the next desired track
+		from := -1
+		for j := i; j < len(order); j++ {
+			if order[j].ID == track.ID {
+				from = j
+				break
+			}
+		}
+		if from < 0 {
+			continue
+		}
+		next = i + 1
+		if from == i {
+			continue
+		}
+
+		// Pull the track forward to position i
+		moved := order[from]
+		copy(order[i+1:from+1], order[i:from])
+		order[i] = moved
+		moves = append(moves, TrackMove{Track: moved, From: from, To: i})
+	}
+	return moves
+}
+
+// ApplySync updates the target playlist to the matched tracks in file by
+// applying only the additions, removals and reorders that are needed.
+func ApplySync(target MusicService, playlist string, file string) error {
+	cp, err := NewCheckpoint(target, playlist, file, modeSync)
+	if err != nil {
+		return err
+	}
+	return cp.sync(target)
+}
+
+// Apply returns current after the removals and appends of the diff, the
+// order SyncTracks reorders from. Like DiffPlaylist it removes the last
+// copies of a track.
+func (d PlaylistDiff) Apply(current []Track) []Track {
+	keep := map[string]int{}
+	for _, track := range current {
+		keep[track.ID]++
+	}
+	for _, track := range d.Remove {
+		keep[track.ID]--
+	}
+	var result []Track
+	for _, track := range current {
+		if track.ID == "" {
+			result = append(result, track)
+			continue
+		}
+		if keep[track.ID] > 0 {
+			keep[track.ID]--
+			result = append(result, track)
+		}
+	}
+	return append(result, d.Add...)
+}
+
+// SyncTracks brings playlist to the desired tracks in order and returns its
+// contents afterwards along with the results of adding the missing tracks.
+func SyncTracks(target MusicService, playlist string, desired []Track) ([]Track, []BatchResult, error) {
+	current, err := target.ReadPlaylist(playlist)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff := DiffPlaylist(current.Tracks, desired)
+	fmt.Printf("Syncing %s playlist: %s (%d to add, %d to remove)\n", target.DisplayName(), playlist, len(diff.Add), len(diff.Remove))
+	reorders := len(PlanMoves(diff.Apply(current.Tracks), desired))
+	estimate := QuotaEstimate{
+		quotaDelete: len(diff.Remove),
+		quotaInsert: len(diff.Add),
+		quotaUpdate: reorders,
+	}
+	if err := CheckQuota(target, "syncing the playlist", estimate); err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the current contents before removing or reordering anything
+	if len(diff.Remove) > 0 || reorders > 0 {
+		if _, err := SaveBackup(target, playlist, current, "sync"); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Remove tracks that are no longer on the source
+	if len(diff.Remove) > 0 {
+		if err := target.RemoveTracks(playlist, diff.Remove); err != nil {
+			return nil, nil, err
+		}
+		for _, track := range diff.Remove {
+			fmt.Printf("Removed %s by %s\n", track.Name, track.Artist())
+		}
+	}
+
+	// Append missing tracks, they are put in place by the moves below
+	failed := 0
+	results := []BatchResult{}
+	if len(diff.Add) > 0 {
+		results = appendTracks(target, playlist, diff.Add)
+		failed = PrintBatchSummary(results)
+	}
+
+	// Read the playlist again for the real order and item IDs
+	updated, err := target.ReadPlaylist(playlist)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Moves are sent by playlist position, which runs ahead of the index
+	// when the service skipped unavailable items while reading
+	moves := PlanMoves(updated.Tracks, desired)
+	final := updated.Tracks
+	for _, move := range moves {
+		if err := target.MoveTrack(playlist, move.Track, final[move.From].Position, final[move.To].Position); err != nil {
+			return nil, nil, err
+		}
+		final = applyMoves(final, []TrackMove{move})
+	}
+
+	fmt.Printf("Synced playlist: %d added, %d removed, %d moved\n", len(diff.Add)-failed, len(diff.Remove), len(moves))
+	if failed > 0 {
+		return final, results, fmt.Errorf("%d of %d tracks could not be added", failed, len(diff.Add))
+	}
+	return final, results, nil
+}
+
+// appendTracks adds tracks to the end of playlist. A batch the service
+// refuses is sent again one track at a time, so only the tracks at fault
+// fail.
+func appendTracks(target MusicService, playlist string, tracks []Track) []BatchResult {
+	var results []BatchResult
+	for _, batch := range target.AddTracks(playlist, -1, tracks) {
+		if batch.Err == nil || len(batch.Tracks) == 1 || !refusedTrack(batch.Err) {
+			results = append(results, batch)
+			continue
+		}
+		fmt.Printf("Batch of %d tracks was refused, adding them one at a time\n", len(batch.Tracks))
+		for i := range batch.Tracks {
+			results = append(results, target.AddTracks(playlist, -1, batch.Tracks[i:i+1])...)
+		}
+	}
+	return results
+}
+
+// applyMoves returns tracks in the order after the moves from PlanMoves.
+// Positions follow the tracks, the ones moved past shift by one.
+func applyMoves(tracks []Track, moves []TrackMove) []Track {
+	order := make([]Track, len(tracks))
+	copy(order, tracks)
+	for _, move := range moves {
+		moved := order[move.From]
+		moved.Position = order[move.To].Position
+		if move.To < move.From {
+			for i := move.To; i < move.From; i++ {
+				order[i].Position++
+			}
+			copy(order[move.To+1:move.From+1], order[move.To:move.From])
+		} else {
+			for i := move.From + 1; i <= move.To; i++ {
+				order[i].Position--
+			}
+			copy(order[move.From:move.To], order[move.From+1:move.To+1])
+		}
+		order[move.To] = moved
+	}
+	return order
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// syncTracks turns letters into tracks with the letter as ID, a "_" is a
+// track without an ID such as a Spotify local file.
+func syncTracks(songs string) []Track {
+	var tracks []Track
+	for i, song := range strings.Split(songs, "") {
+		if song == "" {
+			continue
+		}
+		if song == "_" {
+			tracks = append(tracks, Track{Name: "local", Position: i})
+			continue
+		}
+		tracks = append(tracks, Track{ID: song, Name: song, Position: i})
+	}
+	return tracks
+}
+
+func syncSongs(tracks []Track) string {
+	var songs strings.Builder
+	for _, track := range tracks {
+		if track.ID == "" {
+			songs.WriteString("_")
+			continue
+		}
+		songs.WriteString(track.ID)
+	}
+	return songs.String()
+}
+
+func TestDiffPlaylist(t *testing.T) {
+	tests := []struct {
+		current string
+		desired string
+		remove  string
+		add     string
+	}{
+		{"abc", "abc", "", ""},
+		{"abc", "cba", "", ""},
+		{"", "abc", "", "abc"},
+		{"abc", "", "abc", ""},
+		{"abc", "abd", "c", "d"},
+
+		// Surplus copies are removed from the end, missing copies added
+		{"abab", "ab", "ab", ""},
+		{"ab", "abab", "", "ab"},
+		{"aab", "ab", "a", ""},
+
+		// Tracks without an ID are never removed or added
+		{"a_b", "ab", "", ""},
+		{"a_b", "a", "b", ""},
+		{"a", "a_b", "", "b"},
+	}
+
+	for _, test := range tests {
+		diff := DiffPlaylist(syncTracks(test.current), syncTracks(test.desired))
+		remove, add := syncSongs(diff.Remove), syncSongs(diff.Add)
+		if remove != test.remove || add != test.add {
+			t.Errorf("DiffPlaylist(%q, %q) removes %q and adds %q, want %q and %q", test.current, test.desired, remove, add, test.remove, test.add)
+		}
+		if got := syncSongs(diff.Apply(syncTracks(test.current))); len(got) != len(test.current)-len(remove)+len(add) {
+			t.Errorf("DiffPlaylist(%q, %q).Apply = %q, wrong length", test.current, test.desired, got)
+		}
+	}
+}
+
+func TestPlanMoves(t *testing.T) {
+	tests := []struct {
+		current string
+		desired string
+		result  string
+		moves   int
+	}{
+		{"abc", "abc", "abc", 0},
+		{"cab", "abc", "abc", 2},
+		{"cba", "abc", "abc", 2},
+		{"abcd", "dabc", "dabc", 1},
+
+		// Tracks missing from current, such as refused ones, don't hold
+		// back the ones after them
+		{"bdc", "abcd", "bcd", 1},
+		{"bc", "acb", "cb", 1},
+		{"dcb", "abcd", "bcd", 2},
+		{"", "abc", "", 0},
+
+		// Tracks not in desired end up last
+		{"xab", "ab", "abx", 2},
+
+		// Duplicates are kept in order and extra copies end up last
+		{"baa", "aab", "aab", 2},
+		{"aba", "ab", "aba", 0},
+		{"bca", "aab", "abc", 1},
+
+		// Tracks without an ID are never moved themselves
+		{"b_a", "ab", "ab_", 1},
+		{"_ba", "ab", "_ab", 1},
+		{"b_c", "abc", "b_c", 0},
+		{"c_b", "_abc", "bc_", 1},
+	}
+
+	for _, test := range tests {
+		current := syncTracks(test.current)
+		moves := PlanMoves(current, syncTracks(test.desired))
+		result := syncSongs(applyMoves(current, moves))
+		if result != test.result || len(moves) != test.moves {
+			t.Errorf("PlanMoves(%q, %q) gives %q in %d moves, want %q in %d", test.current, test.desired, result, len(moves), test.result, test.moves)
+		}
+	}
+}
+
+func TestMovePositions(t *testing.T) {
+	tests := []struct {
+		playlist string
+		desired  string
+		result   string
+	}{
+		{"abc", "cab", "cab"},
+		{"c-ab", "abc", "abc-"},
+		{"-c-b-a", "abc", "-abc--"},
+		{"ba--c", "cab", "cab--"},
+	}
+
+	for _, test := range tests {
+		// A "-" is an unavailable item, left out when the playlist is read
+		playlist := strings.Split(test.playlist, "")
+		var current []Track
+		for i, song := range playlist {
+			if song != "-" {
+				current = append(current, Track{ID: song, Position: i})
+			}
+		}
+
+		order := current
+		for _, move := range PlanMoves(current, syncTracks(test.desired)) {
+			from, to := order[move.From].Position, order[move.To].Position
+			if playlist[from] != move.Track.ID {
+				t.Errorf("moving %q in %q: %q is at %d", move.Track.ID, test.playlist, playlist[from], from)
+				break
+			}
+			playlist = slices.Insert(slices.Delete(playlist, from, from+1), to, move.Track.ID)
+			order = applyMoves(order, []TrackMove{move})
+		}
+		if result := strings.Join(playlist, ""); result != test.result {
+			t.Errorf("syncing %q to %q gives %q, want %q", test.playlist, test.desired, result, test.result)
+		}
+		for _, track := range order {
+			if playlist[track.Position] != track.ID {
+				t.Errorf("syncing %q to %q leaves %q at %d, not %q", test.playlist, test.desired, playlist[track.Position], track.Position, track.ID)
+			}
+		}
+	}
+}
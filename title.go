@@ -0,0 +1,189 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Bracketed upload decorations that are not part of the song title, such
+// as "(Official Music Video)", "[HD]" or "(Remastered 2009)"
+var videoDecoration = regexp.MustCompile(`(?i)^\s*(?:` +
+	`(?:official|music|lyrics?|audio|hd|hq|4k|visuali[sz]er|video|clip|officiel|oficial|m/?v|with|remaster(?:ed)?)(?:\s+|$))+$|(?i)^\s*(?:` +
+	`(?:\d{4}\s+)?remaster(?:ed)?(?:\s+\d{4})?(?:\s+version)?|` +
+	`(?:explicit|clean)(?:\s+version)?|` +
+	`full\s+(?:song|audio)` +
+	`)\s*$`)
+
+// Unbracketed decorations at the end of a title, "Song Official Video"
+var trailingDecoration = regexp.MustCompile(`(?i)\s+(?:official\s+(?:music\s+)?(?:video|audio|lyric\s+video|visuali[sz]er)|lyric\s+video|lyrics|hd|hq|4k)$`)
+
+// Featured artist markers, both bracketed and inline
+var featuring = regexp.MustCompile(`(?i)^\s*(feat\.?|ft\.?|featuring|with)\s+(.+?)\s*$`)
+var inlineFeaturing = regexp.MustCompile(`(?i)\s+(feat\.?|ft\.?|featuring)\s+(.+)$`)
+
+// Separators uploaders put between artist and title
+var titleSeparators = []string{" - ", " – ", " — ", " -- ", " ~ "}
+
+// ParseVideoTitle extracts the artist and song title from a YouTube video
+// title such as "Artist - Song (Official Music Video) [HD]". The channel
+// name is used as the artist only when the title doesn't name one.
+func ParseVideoTitle(title string, channel string) (string, []string) {
+	title = html.UnescapeString(strings.TrimSpace(title))
+	channel = html.UnescapeString(strings.TrimSpace(channel))
+
+	// Auto generated "Artist - Topic" channels already have clean titles
+	topic := strings.HasSuffix(channel, " - Topic")
+	channel = cleanChannelName(channel)
+
+	var featured []string
+	title, featured = stripBracketed(title)
+
+	// Anything after a pipe is almost always "Official Video" and friends
+	if i := strings.Index(title, " | "); i > 0 {
+		title = title[:i]
+	}
+
+	var artist string
+	if !topic {
+		for _, sep := range titleSeparators {
+			if left, right, found := strings.Cut(title, sep); found && strings.TrimSpace(left) != "" && strings.TrimSpace(right) != "" {
+				artist, title = left, right
+				break
+			}
+		}
+	}
+
+	// Drop unbracketed decorations left at the end, "Song Official Video"
+	title = trimTrailingDecorations(title)
+
+	// Pull "feat. X" out of the title and artist
+	if m := inlineFeaturing.FindStringSubmatchIndex(title); m != nil {
+		featured = append(featured, title[m[4]:m[5]])
+		title = title[:m[0]]
+	}
+	if m := inlineFeaturing.FindStringSubmatchIndex(artist); m != nil {
+		featured = append([]string{artist[m[4]:m[5]]}, featured...)
+		artist = artist[:m[0]]
+	}
+
+	title = trimQuotes(strings.TrimSpace(title))
+	artist = strings.TrimSpace(artist)
+	if artist == "" {
+		artist = channel
+	}
+
+	var artists []string
+	if artist != "" {
+		artists = append(artists, artist)
+	}
+	for _, name := range featured {
+		for _, part := range strings.Split(name, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				artists = append(artists, part)
+			}
+		}
+	}
+	return title, artists
+}
+
+// stripBracketed removes decoration groups like "(Official Video)" and
+// "[HD]", returning featured artists found in "(feat. X)" groups.
+func stripBracketed(title string) (string, []string) {
+	closers := map[rune]rune{'(': ')', '[': ']', '【': '】'}
+	var featured []string
+	var b strings.Builder
+	runes := []rune(title)
+	for i := 0; i < len(runes); i++ {
+		closer, ok := closers[runes[i]]
+		if !ok {
+			b.WriteRune(runes[i])
+			continue
+		}
+		end := -1
+		for j := i + 1; j < len(runes); j++ {
+			if runes[j] == closer {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			b.WriteString(string(runes[i:]))
+			break
+		}
+
+		inner := string(runes[i+1 : end])
+		switch {
+		case videoDecoration.MatchString(inner):
+		case featuring.MatchString(inner):
+			featured = append(featured, featuring.FindStringSubmatch(inner)[2])
+		default:
+			b.WriteString(string(runes[i : end+1]))
+		}
+		i = end
+	}
+	return strings.Join(strings.Fields(b.String()), " "), featured
+}
+
+func trimTrailingDecorations(title string) string {
+	for {
+		trimmed := trailingDecoration.ReplaceAllString(title, "")
+		// "Song - Remastered 2011"
+		if i := strings.LastIndex(trimmed, " - "); i > 0 && videoDecoration.MatchString(trimmed[i+3:]) {
+			trimmed = trimmed[:i]
+		}
+		if trimmed == title || strings.TrimSpace(trimmed) == "" {
+			return title
+		}
+		title = trimmed
+	}
+}
+
+// trimQuotes unquotes a title that starts with a quoted song name, as in
+// "Creep" (Live).
+func trimQuotes(title string) string {
+	for _, pair := range []string{`""`, "“”"} {
+		quotes := []rune(pair)
+		runes := []rune(title)
+		if len(runes) < 3 || runes[0] != quotes[0] {
+			continue
+		}
+		for i := len(runes) - 1; i > 1; i-- {
+			if runes[i] == quotes[1] {
+				return string(runes[1:i]) + string(runes[i+1:])
+			}
+		}
+	}
+	return title
+}
+
+func cleanChannelName(channel string) string {
+	channel = strings.TrimSuffix(channel, " - Topic")
+	for _, suffix := range []string{"VEVO", "Vevo"} {
+		if name, found := strings.CutSuffix(channel, suffix); found {
+			// VEVO channels run the artist's name together, "EdSheeranVEVO"
+			if !strings.Contains(name, " ") {
+				name = splitCamelCase(name)
+			}
+			channel = name
+		}
+	}
+	channel = strings.TrimSuffix(channel, " Official")
+	return strings.TrimSpace(channel)
+}
+
+// splitCamelCase puts a space wherever a lower case letter is followed by
+// an upper case one.
+func splitCamelCase(name string) string {
+	var b strings.Builder
+	var prev rune
+	for _, r := range name {
+		if unicode.IsLower(prev) && unicode.IsUpper(r) {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
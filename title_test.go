@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseVideoTitle(t *testing.T) {
+	tests := []struct {
+		title   string
+		channel string
+		name    string
+		artists []string
+	}{
+		// Topic channels have clean titles and name the artist
+		{"Bohemian Rhapsody", "Queen - Topic", "Bohemian Rhapsody", []string{"Queen"}},
+		{"Here Comes The Sun - Remastered 2009", "The Beatles - Topic", "Here Comes The Sun", []string{"The Beatles"}},
+		{"Ain't No Sunshine", "Bill Withers - Topic", "Ain't No Sunshine", []string{"Bill Withers"}},
+
+		// VEVO and official channels
+		{"Rick Astley - Never Gonna Give You Up (Official Music Video)", "Rick Astley", "Never Gonna Give You Up", []string{"Rick Astley"}},
+		{"Shape of You (Official Video)", "EdSheeranVEVO", "Shape of You", []string{"Ed Sheeran"}},
+		{"Shape of You (Official Video)", "Ed Sheeran VEVO", "Shape of You", []string{"Ed Sheeran"}},
+		{"Shake It Off", "TaylorSwiftVEVO", "Shake It Off", []string{"Taylor Swift"}},
+		{"Hello (Official Video)", "AdeleVEVO", "Hello", []string{"Adele"}},
+		{"Skyfall (Official Lyric Video)", "Adele Vevo", "Skyfall", []string{"Adele"}},
+		{"Bad Guy", "Billie Eilish Official", "Bad Guy", []string{"Billie Eilish"}},
+
+		// Separators
+		{"Daft Punk – Get Lucky", "Daft Punk", "Get Lucky", []string{"Daft Punk"}},
+		{"Radiohead — Karma Police", "Radiohead", "Karma Police", []string{"Radiohead"}},
+		{"Adele - Hello | Official Video", "AdeleVEVO", "Hello", []string{"Adele"}},
+		{"Hello | Adele", "AdeleVEVO", "Hello", []string{"Adele"}},
+
+		// Featured artists
+		{"Mark Ronson - Uptown Funk (feat. Bruno Mars)", "Mark Ronson", "Uptown Funk", []string{"Mark Ronson", "Bruno Mars"}},
+		{"Calvin Harris - This Is What You Came For ft. Rihanna", "CalvinHarrisVEVO", "This Is What You Came For", []string{"Calvin Harris", "Rihanna"}},
+		{"Drake ft. Rihanna, Future - Too Good", "Drake", "Too Good", []string{"Drake", "Rihanna", "Future"}},
+		{"Old Town Road [feat. Billy Ray Cyrus]", "Lil Nas X", "Old Town Road", []string{"Lil Nas X", "Billy Ray Cyrus"}},
+
+		// Remasters and decorations
+		{"The Rolling Stones - Paint It, Black - Remastered 2011", "The Rolling Stones", "Paint It, Black", []string{"The Rolling Stones"}},
+		{"Queen - Don't Stop Me Now (Remastered 2011) [HD]", "Queen Official", "Don't Stop Me Now", []string{"Queen"}},
+		{"Nirvana - Smells Like Teen Spirit Official Video", "Nirvana", "Smells Like Teen Spirit", []string{"Nirvana"}},
+		{"Eminem - Lose Yourself (Lyrics)", "Lyrics Channel", "Lose Yourself", []string{"Eminem"}},
+		{"Coldplay - Yellow (Live)", "Coldplay", "Yellow (Live)", []string{"Coldplay"}},
+
+		// Quoted titles
+		{`Radiohead - "Creep"`, "Radiohead", "Creep", []string{"Radiohead"}},
+		{`Jeff Buckley - "Hallelujah" (Official Video)`, "JeffBuckleyVEVO", "Hallelujah", []string{"Jeff Buckley"}},
+		{"Frank Sinatra - “My Way” (Live)", "Frank Sinatra", "My Way (Live)", []string{"Frank Sinatra"}},
+
+		// HTML entities left in API titles
+		{"Simon &amp; Garfunkel - The Sound of Silence", "Simon & Garfunkel", "The Sound of Silence", []string{"Simon & Garfunkel"}},
+		{"Guns N&#39; Roses - Sweet Child O&#39; Mine", "Guns N' Roses", "Sweet Child O' Mine", []string{"Guns N' Roses"}},
+		{"Don&#39;t Stop Believin&#39;", "Journey - Topic", "Don't Stop Believin'", []string{"Journey"}},
+	}
+
+	for _, test := range tests {
+		name, artists := ParseVideoTitle(test.title, test.channel)
+		if name != test.name || !slices.Equal(artists, test.artists) {
+			t.Errorf("ParseVideoTitle(%q, %q) = %q, %q, want %q, %q", test.title, test.channel, name, artists, test.name, test.artists)
+		}
+	}
+}
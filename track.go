@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Version of the cached playlist JSON layout, bumped whenever fields change
+// meaning so older files can be migrated on load.
+const playlistSchemaVersion = 1
+
+type Track struct {
+	ID          string   `json:"id"`
+	URI         string   `json:"uri,omitempty"`
+	ItemID      string   `json:"item_id,omitempty"`
+	Name        string   `json:"name"`
+	Artists     []string `json:"artists"`
+	Album       string   `json:"album,omitempty"`
+	DurationMs  int      `json:"duration_ms,omitempty"`
+	ISRC        string   `json:"isrc,omitempty"`
+	ReleaseYear int      `json:"release_year,omitempty"`
+	Explicit    bool     `json:"explicit,omitempty"`
+	TargetID    string   `json:"target_id,omitempty"`
+	MatchScore  float64  `json:"match_score,omitempty"`
+
+	// Index of the source track a matched track was made from, for the
+	// transfer report
+	Source int `json:"source,omitempty"`
+
+	// Search results kept from matching for tracks that need review
+	Candidates []ScoredTrack `json:"candidates,omitempty"`
+
+	// Index on the playlist it was read from, Spotify removes by position
+	Position int `json:"-"`
+}
+
+type Playlist struct {
+	SchemaVersion int     `json:"schema_version"`
+	Service       string  `json:"service"`
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	TargetService string  `json:"target_service,omitempty"`
+	Tracks        []Track `json:"tracks"`
+}
+
+func (t Track) Artist() string {
+	return strings.Join(t.Artists, ", ")
+}
+
+// TargetTracks returns the matched tracks as tracks of the target service,
+// skipping any that could not be resolved.
+func (p *Playlist) TargetTracks() []Track {
+	var tracks []Track
+	for i, track := range p.Tracks {
+		if track.TargetID == "" {
+			continue
+		}
+		matched := track
+		matched.Source = i
+		matched.ID = track.TargetID
+		matched.URI = ""
+		matched.ItemID = ""
+		matched.TargetID = ""
+		matched.MatchScore = 0
+		matched.Candidates = nil
+		tracks = append(tracks, matched)
+	}
+	return tracks
+}
+
+func playlistFilePath(service string, playlist string) string {
+	return filepath.Join(CacheDir(), service, playlist+".json")
+}
+
+func LoadPlaylistFile(path string) (*Playlist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist file: %v", err)
+	}
+
+	// Files written before the schema version was introduced are a bare
+	// list of name/artist/id maps
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var songs []map[string]string
+		if err := json.Unmarshal(data, &songs); err != nil {
+			return nil, fmt.Errorf("error parsing playlist file: %v", err)
+		}
+		playlist := &Playlist{SchemaVersion: playlistSchemaVersion}
+		for _, song := range songs {
+			playlist.Tracks = append(playlist.Tracks, Track{
+				ID:      song["id"],
+				Name:    song["name"],
+				Artists: splitArtists(song["artist"]),
+			})
+		}
+		return playlist, nil
+	}
+
+	var playlist Playlist
+	if err := json.Unmarshal(data, &playlist); err != nil {
+		return nil, fmt.Errorf("error parsing playlist file: %v", err)
+	}
+	if playlist.SchemaVersion > playlistSchemaVersion {
+		return nil, fmt.Errorf("playlist file %s has unsupported schema version %d", path, playlist.SchemaVersion)
+	}
+	playlist.SchemaVersion = playlistSchemaVersion
+	return &playlist, nil
+}
+
+func SavePlaylistFile(path string, playlist *Playlist) error {
+	// Create storage directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating storage directory: %v", err)
+	}
+
+	playlist.SchemaVersion = playlistSchemaVersion
+	jsonData, err := json.MarshalIndent(playlist, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling playlist: %v", err)
+	}
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing playlist file: %v", err)
+	}
+	return nil
+}
+
+func splitArtists(artist string) []string {
+	var artists []string
+	for _, name := range strings.Split(artist, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			artists = append(artists, name)
+		}
+	}
+	return artists
+}
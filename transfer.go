@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type TransferOptions struct {
+	Host        MusicService
+	Matcher     *Matcher
+	SourceID    string
+	TargetID    string
+	TargetName  string
+	Description string
+	Public      bool
+	Mode        string
+	Conflicts   string
+	Review      bool
+	DryRun      bool
+	PlanFile    string
+}
+
+// ParsePlaylistRef splits a "service:id" reference such as
+// "spotify:37i9dQZF1DXcBWIGoYBM5M" into its service and playlist ID.
+func ParsePlaylistRef(ref string) (string, string, error) {
+	service, id, found := strings.Cut(ref, ":")
+	if !found || service == "" || id == "" {
+		return "", "", fmt.Errorf("invalid playlist reference %q: expected service:id", ref)
+	}
+	return serviceName(service), id, nil
+}
+
+// PrepareTransfer reads the source playlist into the cache and resolves
+// every track on the target service, returning the cached file path.
+func PrepareTransfer(ctx context.Context, host MusicService, matcher *Matcher, playlist string) (string, error) {
+	fmt.Printf("Parsing playlist: %s\n", playlist)
+	source, err := ReadPlaylist(host, playlist)
+	if err != nil {
+		return "", err
+	}
+	action := fmt.Sprintf("matching %d tracks", len(source.Tracks))
+	if err := CheckQuota(matcher.Target, action, EstimateMatching(matcher, source)); err != nil {
+		return "", err
+	}
+
+	file := playlistFilePath(host.Name(), playlist)
+	if err := FindTrackIDFromFile(ctx, matcher, file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// ApplyTransfer updates the target playlist to the matched tracks in file.
+// Replace mode clears the playlist and adds everything again, sync mode
+// only applies the differences.
+func ApplyTransfer(ctx context.Context, target MusicService, playlist string, file string, mode string) error {
+	if mode == modeSync {
+		return ApplySync(target, playlist, file)
+	}
+	if err := checkReplaceQuota(target, playlist, file); err != nil {
+		return err
+	}
+	cp, err := NewCheckpoint(target, playlist, file, modeReplace)
+	if err != nil {
+		return err
+	}
+	if err := cp.backup(target); err != nil {
+		return err
+	}
+	if err := target.ClearPlaylist(playlist); err != nil {
+		return cp.stopped(err)
+	}
+	cp.Cleared = true
+	fmt.Printf("Transferring playlist: %s\n", playlist)
+	return cp.Run(ctx, target)
+}
+
+// checkReplaceQuota makes sure clearing and refilling playlist fits in
+// the target's quota before anything is removed.
+func checkReplaceQuota(target MusicService, playlist string, file string) error {
+	if quotaLedger(target.Name()) == nil {
+		return nil
+	}
+	current, err := target.ReadPlaylist(playlist)
+	if err != nil {
+		return err
+	}
+	source, err := LoadPlaylistFile(file)
+	if err != nil {
+		return err
+	}
+	estimate := QuotaEstimate{quotaDelete: len(current.Tracks), quotaInsert: len(source.TargetTracks())}
+	return CheckQuota(target, "replacing the playlist", estimate)
+}
+
+// Transfer copies the source playlist to the target and returns the target
+// playlist ID, which is new when opts.TargetID is empty. Cancelling ctx
+// stops matching, nothing is written to the target after that.
+func Transfer(ctx context.Context, opts TransferOptions) (string, error) {
+	if opts.Mode == modeTwoWay {
+		if opts.TargetID == "" {
+			return "", fmt.Errorf("two-way sync needs an existing target playlist")
+		}
+		return opts.TargetID, TwoWaySync(ctx, TwoWayOptions{
+			Source:    opts.Matcher.For(opts.Host),
+			SourceID:  opts.SourceID,
+			Target:    opts.Matcher,
+			TargetID:  opts.TargetID,
+			Conflicts: opts.Conflicts,
+			DryRun:    opts.DryRun,
+		})
+	}
+
+	file, err := PrepareTransfer(ctx, opts.Host, opts.Matcher, opts.SourceID)
+	if err != nil {
+		return "", err
+	}
+	if opts.Review {
+		if err := ReviewMatches(ctx, opts.Matcher, file, bufio.NewReader(os.Stdin)); err != nil {
+			return "", err
+		}
+	}
+	target := opts.Matcher.Target
+
+	if opts.DryRun {
+		name := opts.TargetName
+		if name == "" && opts.TargetID == "" {
+			if source, err := LoadPlaylistFile(file); err == nil {
+				name = source.Name
+			}
+		}
+		return opts.TargetID, PreviewTransfer(target, opts.TargetID, name, file, opts.Mode, opts.PlanFile)
+	}
+
+	// Existing playlists are cleared or synced, new ones start empty
+	if opts.TargetID != "" {
+		return opts.TargetID, ApplyTransfer(ctx, target, opts.TargetID, file, opts.Mode)
+	}
+
+	source, err := LoadPlaylistFile(file)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckQuota(target, "filling the new playlist", QuotaEstimate{quotaInsert: len(source.TargetTracks())}); err != nil {
+		return "", err
+	}
+	name := opts.TargetName
+	if name == "" {
+		name = source.Name
+	}
+	targetID, err := target.CreatePlaylist(name, opts.Description, opts.Public)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Transferring playlist: %s\n", targetID)
+	return targetID, UpdatePlaylist(ctx, target, targetID, file)
+}
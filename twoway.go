@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const baselineSchemaVersion = 1
+
+// Ways to resolve a track that was removed on one side and moved on the
+// other, or a playlist reordered on both sides
+const (
+	conflictKeep   = "keep"
+	conflictRemove = "remove"
+	conflictSource = "source"
+	conflictTarget = "target"
+)
+
+type SyncConfig struct {
+	Conflicts string `yaml:"conflicts"`
+}
+
+// SyncPair is the same song on both playlists of a two-way link.
+type SyncPair struct {
+	Source Track `json:"source"`
+	Target Track `json:"target"`
+}
+
+// SyncBaseline is what both playlists looked like after the last two-way
+// sync. Changes on each side are computed against it.
+type SyncBaseline struct {
+	SchemaVersion int        `json:"schema_version"`
+	SourceService string     `json:"source_service"`
+	SourceID      string     `json:"source_id"`
+	TargetService string     `json:"target_service"`
+	TargetID      string     `json:"target_id"`
+	SyncedAt      time.Time  `json:"synced_at"`
+	Tracks        []SyncPair `json:"tracks"`
+}
+
+type SyncConflict struct {
+	Track      string
+	Reason     string
+	Resolution string
+}
+
+type TwoWayOptions struct {
+	Source       *Matcher
+	SourceID     string
+	Target       *Matcher
+	TargetID     string
+	Conflicts    string
+	DryRun       bool
+	BaselineFile string
+}
+
+// syncSide is one playlist of a two-way link compared to the baseline.
+type syncSide struct {
+	name     string
+	matcher  *Matcher
+	playlist string
+	tracks   []Track
+
+	// Contents after the sync, once the additions are matched
+	desired []Track
+
+	// Baseline pair of each current track, -1 for tracks added since
+	pairs   []int
+	removed map[int]bool
+	moved   map[int]bool
+}
+
+type syncEntry struct {
+	pair SyncPair
+	base int
+}
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case conflictKeep, conflictRemove, conflictSource, conflictTarget:
+		return true
+	}
+	return false
+}
+
+func baselineFilePath(sourceService string, sourceID string, targetService string, targetID string) string {
+	name := fmt.Sprintf("%s_%s__%s_%s.json", sourceService, sourceID, targetService, targetID)
+	return filepath.Join(DataDir(), "baselines", name)
+}
+
+// LoadBaseline reads the baseline at path. A missing file is an empty
+// baseline, as before the first sync.
+func LoadBaseline(path string) (*SyncBaseline, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &SyncBaseline{SchemaVersion: baselineSchemaVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading baseline: %v", err)
+	}
+	var baseline SyncBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("error parsing baseline: %v", err)
+	}
+	return &baseline, nil
+}
+
+func SaveBaseline(path string, baseline *SyncBaseline) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating baseline directory: %v", err)
+	}
+	data, err := json.MarshalIndent(baseline, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling baseline: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing baseline: %v", err)
+	}
+	return nil
+}
+
+// TwoWaySync merges the changes made to either playlist since the last
+// sync into the other one. Tracks added on one side are matched onto the
+// other, removals and reorders are propagated, and conflicting edits are
+// resolved with the conflict policy. Cancelling ctx stops matching, neither
+// playlist is changed after that.
+func TwoWaySync(ctx context.Context, opts TwoWayOptions) error {
+	policy := opts.Conflicts
+	if policy == "" {
+		policy = conflictKeep
+	}
+	if !validConflictPolicy(policy) {
+		return fmt.Errorf("invalid conflict policy %q: must be keep, remove, source or target", policy)
+	}
+	sourceService, targetService := opts.Source.Target, opts.Target.Target
+
+	path := opts.BaselineFile
+	if path == "" {
+		path = baselineFilePath(sourceService.Name(), opts.SourceID, targetService.Name(), opts.TargetID)
+	}
+	baseline, err := LoadBaseline(path)
+	if err != nil {
+		return err
+	}
+	if baseline.SyncedAt.IsZero() {
+		fmt.Println("No baseline found, merging both playlists")
+	}
+
+	// Read both sides
+	source := &syncSide{name: "source", matcher: opts.Source, playlist: opts.SourceID}
+	target := &syncSide{name: "target", matcher: opts.Target, playlist: opts.TargetID}
+	for _, side := range []*syncSide{source, target} {
+		playlist, err := side.matcher.Target.ReadPlaylist(side.playlist)
+		if err != nil {
+			return err
+		}
+		side.tracks = playlist.Tracks
+		side.compare(baseline.Tracks, side == source)
+	}
+
+	// Removals, unless the other side moved the track
+	var conflicts []SyncConflict
+	keep := make([]bool, len(baseline.Tracks))
+	for i, pair := range baseline.Tracks {
+		switch {
+		case source.removed[i] && target.removed[i]:
+		case source.removed[i] && target.moved[i]:
+			keep[i] = policy == conflictKeep || policy == conflictTarget
+			conflicts = append(conflicts, newConflict(pair.Source, "removed on source, moved on target", keep[i]))
+		case target.removed[i] && source.moved[i]:
+			keep[i] = policy == conflictKeep || policy == conflictSource
+			conflicts = append(conflicts, newConflict(pair.Source, "removed on target, moved on source", keep[i]))
+		default:
+			keep[i] = !source.removed[i] && !target.removed[i]
+		}
+	}
+
+	// Order follows the side that was reordered
+	order, other := source, target
+	if len(target.moved) > 0 && (len(source.moved) == 0 || policy == conflictTarget) {
+		order, other = target, source
+	}
+	if len(source.moved) > 0 && len(target.moved) > 0 {
+		conflicts = append(conflicts, SyncConflict{
+			Reason:     "reordered on both sides",
+			Resolution: "kept " + order.name + " order",
+		})
+	}
+
+	merged := mergeSides(baseline.Tracks, keep, order, other)
+	if err := checkAdditionsQuota(merged, source, target); err != nil {
+		return err
+	}
+	merged, err = matchAdditions(ctx, merged, source, target)
+	for _, matcher := range []*Matcher{opts.Source, opts.Target} {
+		if matcher.Cache != nil {
+			if err := matcher.Cache.Save(); err != nil {
+				fmt.Printf("Error saving match cache: %v\n", err)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Desired contents of each side, unmatched additions stay where they are
+	for _, entry := range merged {
+		if entry.pair.Source.ID != "" {
+			source.desired = append(source.desired, entry.pair.Source)
+		}
+		if entry.pair.Target.ID != "" {
+			target.desired = append(target.desired, entry.pair.Target)
+		}
+	}
+
+	for _, conflict := range conflicts {
+		if conflict.Track != "" {
+			fmt.Printf("Conflict: %s %s (%s)\n", conflict.Track, conflict.Reason, conflict.Resolution)
+		} else {
+			fmt.Printf("Conflict: %s (%s)\n", conflict.Reason, conflict.Resolution)
+		}
+	}
+
+	if opts.DryRun {
+		for _, side := range []*syncSide{source, target} {
+			diff := DiffPlaylist(side.tracks, side.desired)
+			fmt.Printf("\n%s %s:%s\n", side.name, side.matcher.Target.Name(), side.playlist)
+			for _, track := range diff.Remove {
+				fmt.Printf("- remove %s by %s\n", track.Name, track.Artist())
+			}
+			for _, track := range diff.Add {
+				fmt.Printf("- add %s by %s\n", track.Name, track.Artist())
+			}
+		}
+		fmt.Println("\nNo changes were made")
+		return nil
+	}
+
+	// Apply to both sides, then keep only pairs that made it onto both.
+	// Tracks that failed to add are retried as additions next time.
+	if err := checkChangesQuota(source, target); err != nil {
+		return err
+	}
+	finalSource, _, sourceErr := SyncTracks(sourceService, opts.SourceID, source.desired)
+	if finalSource == nil {
+		return sourceErr
+	}
+	finalTarget, _, targetErr := SyncTracks(targetService, opts.TargetID, target.desired)
+	if finalTarget == nil {
+		return targetErr
+	}
+	present := func(tracks []Track) map[string]bool {
+		ids := map[string]bool{}
+		for _, track := range tracks {
+			ids[track.ID] = true
+		}
+		return ids
+	}
+	onSource, onTarget := present(finalSource), present(finalTarget)
+
+	next := &SyncBaseline{
+		SchemaVersion: baselineSchemaVersion,
+		SourceService: sourceService.Name(),
+		SourceID:      opts.SourceID,
+		TargetService: targetService.Name(),
+		TargetID:      opts.TargetID,
+		SyncedAt:      time.Now(),
+		Tracks:        []SyncPair{},
+	}
+	for _, entry := range merged {
+		if onSource[entry.pair.Source.ID] && onTarget[entry.pair.Target.ID] {
+			next.Tracks = append(next.Tracks, entry.pair)
+		}
+	}
+	if err := SaveBaseline(path, next); err != nil {
+		return err
+	}
+	fmt.Printf("Two-way sync finished: %d tracks linked, %d conflicts\n", len(next.Tracks), len(conflicts))
+	if sourceErr != nil {
+		return sourceErr
+	}
+	return targetErr
+}
+
+func newConflict(track Track, reason string, kept bool) SyncConflict {
+	resolution := "removed on both"
+	if kept {
+		resolution = "kept on both"
+	}
+	return SyncConflict{Track: fmt.Sprintf("%s by %s", track.Name, track.Artist()), Reason: reason, Resolution: resolution}
+}
+
+// compare finds which baseline pairs the side still has, which it removed
+// and which it moved.
+func (s *syncSide) compare(baseline []SyncPair, isSource bool) {
+	queues := map[string][]int{}
+	for i, pair := range baseline {
+		id := pair.Target.ID
+		if isSource {
+			id = pair.Source.ID
+		}
+		queues[id] = append(queues[id], i)
+	}
+
+	var kept []int
+	s.pairs = make([]int, len(s.tracks))
+	for i, track := range s.tracks {
+		s.pairs[i] = -1
+		if queue := queues[track.ID]; len(queue) > 0 {
+			s.pairs[i] = queue[0]
+			queues[track.ID] = queue[1:]
+			kept = append(kept, queue[0])
+		}
+	}
+
+	s.removed = map[int]bool{}
+	for _, queue := range queues {
+		for _, i := range queue {
+			s.removed[i] = true
+		}
+	}
+
+	// Tracks outside the longest run still in baseline order were moved
+	s.moved = map[int]bool{}
+	inOrder := longestIncreasing(kept)
+	for _, i := range kept {
+		if !inOrder[i] {
+			s.moved[i] = true
+		}
+	}
+}
+
+// longestIncreasing returns the values of the longest increasing
+// subsequence of values.
+func longestIncreasing(values []int) map[int]bool {
+	var tails []int
+	prev := make([]int, len(values))
+	for i, value := range values {
+		j := sort.Search(len(tails), func(k int) bool { return values[tails[k]] >= value })
+		prev[i] = -1
+		if j > 0 {
+			prev[i] = tails[j-1]
+		}
+		if j == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[j] = i
+		}
+	}
+
+	result := map[int]bool{}
+	if len(tails) == 0 {
+		return result
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+		result[values[i]] = true
+	}
+	return result
+}
+
+// mergeSides orders the kept baseline pairs and the additions of both sides
+// by the current order of the order side. Tracks the order side doesn't
+// have are placed after the track they follow on the other side.
+func mergeSides(baseline []SyncPair, keep []bool, order *syncSide, other *syncSide) []*syncEntry {
+	isSource := order.name == "source"
+	newEntry := func(track Track, base int, fromSource bool) *syncEntry {
+		if base >= 0 {
+			return &syncEntry{pair: baseline[base], base: base}
+		}
+		if fromSource {
+			return &syncEntry{pair: SyncPair{Source: track}, base: -1}
+		}
+		return &syncEntry{pair: SyncPair{Target: track}, base: -1}
+	}
+
+	var merged []*syncEntry
+	placed := map[int]*syncEntry{}
+	for i, track := range order.tracks {
+		base := order.pairs[i]
+		if base >= 0 && !keep[base] {
+			continue
+		}
+		entry := newEntry(track, base, isSource)
+		merged = append(merged, entry)
+		if base >= 0 {
+			placed[base] = entry
+		}
+	}
+
+	insertAfter := func(after *syncEntry, entry *syncEntry) {
+		at := 0
+		for i, e := range merged {
+			if e == after {
+				at = i + 1
+				break
+			}
+		}
+		merged = append(merged, nil)
+		copy(merged[at+1:], merged[at:])
+		merged[at] = entry
+	}
+
+	// Pairs kept despite being removed on the order side, at their old spot
+	for base := range baseline {
+		if !keep[base] || placed[base] != nil {
+			continue
+		}
+		var after *syncEntry
+		for i := base - 1; i >= 0 && after == nil; i-- {
+			after = placed[i]
+		}
+		entry := &syncEntry{pair: baseline[base], base: base}
+		insertAfter(after, entry)
+		placed[base] = entry
+	}
+
+	// Additions on the other side, after the track they follow there
+	var after *syncEntry
+	for i, track := range other.tracks {
+		base := other.pairs[i]
+		if base >= 0 {
+			if placed[base] != nil {
+				after = placed[base]
+			}
+			continue
+		}
+		entry := newEntry(track, -1, !isSource)
+		if after == nil && len(baseline) == 0 {
+			// Nothing is known about the order on the first sync
+			merged = append(merged, entry)
+		} else {
+			insertAfter(after, entry)
+		}
+		after = entry
+	}
+	return merged
+}
+
+// checkAdditionsQuota estimates the searches for matching the additions of
+// each side onto the other before any are made.
+func checkAdditionsQuota(merged []*syncEntry, source *syncSide, target *syncSide) error {
+	for _, from := range []*syncSide{source, target} {
+		onto := target
+		if from == target {
+			onto = source
+		}
+		added := &Playlist{Service: from.matcher.Target.Name()}
+		for _, entry := range merged {
+			switch {
+			case entry.base >= 0:
+			case from == source && entry.pair.Target.ID == "":
+				added.Tracks = append(added.Tracks, entry.pair.Source)
+			case from == target && entry.pair.Source.ID == "":
+				added.Tracks = append(added.Tracks, entry.pair.Target)
+			}
+		}
+		if len(added.Tracks) == 0 {
+			continue
+		}
+		action := fmt.Sprintf("matching %d tracks added on the %s", len(added.Tracks), from.name)
+		if err := CheckQuota(onto.matcher.Target, action, EstimateMatching(onto.matcher, added)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkChangesQuota prices the changes to both sides before either is
+// changed, together when both are on the same service.
+func checkChangesQuota(source *syncSide, target *syncSide) error {
+	var services []MusicService
+	estimates := map[string]QuotaEstimate{}
+	for _, side := range []*syncSide{source, target} {
+		service := side.matcher.Target
+		estimate, found := estimates[service.Name()]
+		if !found {
+			estimate = QuotaEstimate{}
+			estimates[service.Name()] = estimate
+			services = append(services, service)
+		}
+		diff := DiffPlaylist(side.tracks, side.desired)
+		estimate[quotaDelete] += len(diff.Remove)
+		estimate[quotaInsert] += len(diff.Add)
+		estimate[quotaUpdate] += len(PlanMoves(diff.Apply(side.tracks), side.desired))
+	}
+	for _, service := range services {
+		if err := CheckQuota(service, "syncing both playlists", estimates[service.Name()]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchAdditions fills in the missing side of new entries by searching for
+// them. A track added on both sides is linked instead of added twice.
+func matchAdditions(ctx context.Context, merged []*syncEntry, source *syncSide, target *syncSide) ([]*syncEntry, error) {
+	// New tracks on each side not yet linked, by ID
+	added := map[bool]map[string]*syncEntry{true: {}, false: {}}
+	for _, entry := range merged {
+		if entry.base >= 0 {
+			continue
+		}
+		if entry.pair.Target.ID == "" {
+			added[true][entry.pair.Source.ID] = entry
+		} else {
+			added[false][entry.pair.Target.ID] = entry
+		}
+	}
+
+	for i := 0; i < len(merged); i++ {
+		entry := merged[i]
+		if entry.base >= 0 || (entry.pair.Source.ID != "" && entry.pair.Target.ID != "") {
+			continue
+		}
+		fromSource := entry.pair.Target.ID == ""
+		track, from, onto := entry.pair.Source, source, target
+		if !fromSource {
+			track, from, onto = entry.pair.Target, target, source
+		}
+
+		var best Track
+		if onto.matcher.Target.Name() == from.matcher.Target.Name() {
+			best = track
+		} else {
+			match, ok, err := onto.matcher.Resolve(ctx, from.matcher.Target.Name(), track)
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("matching stopped: %w", ctx.Err())
+			}
+			if errors.Is(err, ErrQuota) {
+				// The rest would fail the same way, stop before writing either side
+				return nil, fmt.Errorf("error matching %s: %w", track.Name, err)
+			}
+			if err != nil {
+				fmt.Printf("Error searching for %s: %v\n", track.Name, err)
+			}
+			if !ok {
+				fmt.Printf("No match on %s for %s by %s\n", onto.name, track.Name, track.Artist())
+				continue
+			}
+			best = match.Track
+		}
+
+		// Link with the same track added on the other side
+		if twin := added[!fromSource][best.ID]; twin != nil && twin != entry {
+			if fromSource {
+				best = twin.pair.Target
+			} else {
+				best = twin.pair.Source
+			}
+			delete(added[!fromSource], best.ID)
+			for j := range merged {
+				if merged[j] == twin {
+					merged = append(merged[:j], merged[j+1:]...)
+					if j < i {
+						i--
+					}
+					break
+				}
+			}
+		}
+		if fromSource {
+			entry.pair.Target = best
+		} else {
+			entry.pair.Source = best
+		}
+		delete(added[fromSource], track.ID)
+		fmt.Printf("Matched %s by %s on %s -> %s\n", track.Name, track.Artist(), onto.name, best.ID)
+	}
+	return merged, nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Songs by letter, each on both fake services under its own ID
+var twoWaySongs = map[string]string{
+	"a": "Airbag",
+	"b": "Bones",
+	"c": "Creep",
+	"d": "Daydreaming",
+	"e": "Exit Music",
+	"f": "Fake Plastic Trees",
+}
+
+// fakeService keeps playlists in memory. Track IDs are the service name
+// and the song letter, so results read back as letters.
+type fakeService struct {
+	name      string
+	playlists map[string][]Track
+	searches  int
+}
+
+func newFakeService(name string) *fakeService {
+	return &fakeService{name: name, playlists: map[string][]Track{}}
+}
+
+func (f *fakeService) track(song string) Track {
+	return Track{ID: f.name + "-" + song, Name: twoWaySongs[song], Artists: []string{"Radiohead"}}
+}
+
+func (f *fakeService) set(playlist string, songs string) {
+	f.playlists[playlist] = nil
+	for _, song := range strings.Split(songs, "") {
+		f.playlists[playlist] = append(f.playlists[playlist], f.track(song))
+	}
+}
+
+func (f *fakeService) songs(playlist string) string {
+	var songs strings.Builder
+	for _, track := range f.playlists[playlist] {
+		songs.WriteString(strings.TrimPrefix(track.ID, f.name+"-"))
+	}
+	return songs.String()
+}
+
+func (f *fakeService) Name() string        { return f.name }
+func (f *fakeService) DisplayName() string { return f.name }
+func (f *fakeService) ValidateAuth() error { return nil }
+func (f *fakeService) BatchSize() int      { return 50 }
+
+func (f *fakeService) ListPlaylists() ([]PlaylistSummary, error) {
+	return nil, nil
+}
+
+func (f *fakeService) ReadPlaylist(playlist string) (*Playlist, error) {
+	tracks := slices.Clone(f.playlists[playlist])
+	for i := range tracks {
+		tracks[i].Position = i
+	}
+	return &Playlist{Service: f.name, ID: playlist, Tracks: tracks}, nil
+}
+
+func (f *fakeService) SearchTracks(ctx context.Context, track Track, limit int) ([]Track, error) {
+	f.searches++
+	for song, name := range twoWaySongs {
+		if name == track.Name {
+			return []Track{f.track(song)}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeService) CreatePlaylist(title string, description string, public bool) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func (f *fakeService) ClearPlaylist(playlist string) error {
+	f.playlists[playlist] = nil
+	return nil
+}
+
+func (f *fakeService) AddTracks(playlist string, position int, tracks []Track) []BatchResult {
+	f.playlists[playlist] = append(f.playlists[playlist], tracks...)
+	return []BatchResult{{Position: -1, Tracks: tracks}}
+}
+
+func (f *fakeService) RemoveTracks(playlist string, tracks []Track) error {
+	for _, track := range tracks {
+		i := slices.IndexFunc(f.playlists[playlist], func(t Track) bool { return t.ID == track.ID })
+		if i < 0 {
+			return fmt.Errorf("%s is not on %s", track.ID, playlist)
+		}
+		f.playlists[playlist] = slices.Delete(f.playlists[playlist], i, i+1)
+	}
+	return nil
+}
+
+func (f *fakeService) MoveTrack(playlist string, track Track, from int, to int) error {
+	tracks := f.playlists[playlist]
+	if from >= len(tracks) || tracks[from].ID != track.ID {
+		return fmt.Errorf("%s is not at %d on %s", track.ID, from, playlist)
+	}
+	tracks = slices.Delete(tracks, from, from+1)
+	f.playlists[playlist] = slices.Insert(tracks, to, track)
+	return nil
+}
+
+func (f *fakeService) ParseTrackID(input string) (string, bool) {
+	return input, input != ""
+}
+
+// twoWayLink syncs a playlist on a left service with one on a right
+// service, keeping its baseline in a temporary directory.
+type twoWayLink struct {
+	t            *testing.T
+	left, right  *fakeService
+	baselineFile string
+}
+
+func newTwoWayLink(t *testing.T) *twoWayLink {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dir)
+	return &twoWayLink{
+		t:            t,
+		left:         newFakeService("left"),
+		right:        newFakeService("right"),
+		baselineFile: filepath.Join(dir, "baseline.json"),
+	}
+}
+
+func (l *twoWayLink) sync(policy string) {
+	l.t.Helper()
+	err := TwoWaySync(context.Background(), TwoWayOptions{
+		Source:       NewMatcher(l.left, MatchingConfig{}),
+		SourceID:     "src",
+		Target:       NewMatcher(l.right, MatchingConfig{}),
+		TargetID:     "dst",
+		Conflicts:    policy,
+		BaselineFile: l.baselineFile,
+	})
+	if err != nil {
+		l.t.Fatalf("TwoWaySync: %v", err)
+	}
+}
+
+func (l *twoWayLink) check(want string) {
+	l.t.Helper()
+	if got := l.left.songs("src"); got != want {
+		l.t.Errorf("source is %q, want %q", got, want)
+	}
+	if got := l.right.songs("dst"); got != want {
+		l.t.Errorf("target is %q, want %q", got, want)
+	}
+}
+
+func TestTwoWayFirstMerge(t *testing.T) {
+	link := newTwoWayLink(t)
+	link.left.set("src", "abc")
+	link.right.set("dst", "bd")
+	link.sync("")
+
+	// Tracks on both sides are linked instead of added twice, the target's
+	// own tracks go after the source's
+	link.check("abcd")
+	baseline, err := LoadBaseline(link.baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baseline.Tracks) != 4 {
+		t.Fatalf("baseline has %d pairs, want 4", len(baseline.Tracks))
+	}
+	for _, pair := range baseline.Tracks {
+		if pair.Source.Name != pair.Target.Name {
+			t.Errorf("%s linked with %s", pair.Source.ID, pair.Target.ID)
+		}
+	}
+
+	// Nothing changed, nothing to search or write
+	searches := link.left.searches + link.right.searches
+	link.sync("")
+	link.check("abcd")
+	if link.left.searches+link.right.searches != searches {
+		t.Error("unchanged playlists were searched again")
+	}
+}
+
+func TestTwoWaySync(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		source string
+		target string
+		want   string
+	}{
+		// Changes on one side
+		{"added on source", "", "abfcde", "abcde", "abfcde"},
+		{"added on target", "", "abcde", "abcdef", "abcdef"},
+		{"removed on source", "", "abde", "abcde", "abde"},
+		{"removed on both", "", "abde", "abcd", "abd"},
+		{"reordered on source", "", "eabcd", "abcde", "eabcd"},
+		{"reordered on target", "", "abcde", "acdeb", "acdeb"},
+		{"reordered on source, added on target", "", "eabcd", "abfcde", "eabfcd"},
+
+		// Removed on one side and moved on the other
+		{"remove vs move, keep", conflictKeep, "abde", "cabde", "cabde"},
+		{"remove vs move, remove", conflictRemove, "abde", "cabde", "abde"},
+		{"remove vs move, source", conflictSource, "abde", "cabde", "abde"},
+		{"remove vs move, target", conflictTarget, "abde", "cabde", "cabde"},
+		{"move vs remove, keep", conflictKeep, "cabde", "abde", "cabde"},
+		{"move vs remove, remove", conflictRemove, "cabde", "abde", "abde"},
+		{"move vs remove, source", conflictSource, "cabde", "abde", "cabde"},
+		{"move vs remove, target", conflictTarget, "cabde", "abde", "abde"},
+
+		// Reordered on both sides
+		{"reordered on both, keep", conflictKeep, "bacde", "abced", "bacde"},
+		{"reordered on both, remove", conflictRemove, "bacde", "abced", "bacde"},
+		{"reordered on both, source", conflictSource, "bacde", "abced", "bacde"},
+		{"reordered on both, target", conflictTarget, "bacde", "abced", "abced"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link := newTwoWayLink(t)
+			link.left.set("src", "abcde")
+			link.right.set("dst", "abcde")
+			link.sync("")
+
+			link.left.set("src", test.source)
+			link.right.set("dst", test.target)
+			link.sync(test.policy)
+			link.check(test.want)
+
+			// The next sync starts from the merged playlists
+			link.sync(test.policy)
+			link.check(test.want)
+		})
+	}
+}
+
+func TestTwoWayCancel(t *testing.T) {
+	link := newTwoWayLink(t)
+	link.left.set("src", "abc")
+	link.right.set("dst", "de")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := TwoWaySync(ctx, TwoWayOptions{
+		Source:       NewMatcher(link.left, MatchingConfig{}),
+		SourceID:     "src",
+		Target:       NewMatcher(link.right, MatchingConfig{}),
+		TargetID:     "dst",
+		BaselineFile: link.baselineFile,
+	})
+	if err == nil {
+		t.Fatal("cancelled sync succeeded")
+	}
+	if link.left.songs("src") != "abc" || link.right.songs("dst") != "de" {
+		t.Errorf("cancelled sync changed the playlists to %q and %q", link.left.songs("src"), link.right.songs("dst"))
+	}
+}
+
+func TestTwoWayQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   int
+		searches int
+	}{
+		// Three searches on the right cost 30
+		{"searches over budget", 25, 0},
+		// The searches fit but adding three tracks costs 150
+		{"writes over budget", 100, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link := newTwoWayLink(t)
+			RegisterQuota("right", QuotaSpec{
+				Daily:    10000,
+				Units:    map[string]int{quotaSearch: 10, quotaInsert: 50},
+				CallType: func(method string, url string) string { return quotaSearch },
+			})
+			quotaLedgerFor("right", QuotaConfig{Budget: test.budget})
+			t.Cleanup(func() {
+				delete(quotaSpecs, "right")
+				delete(ledgers, "right")
+			})
+			link.left.set("src", "abc")
+			link.right.set("dst", "d")
+
+			err := TwoWaySync(context.Background(), TwoWayOptions{
+				Source:       NewMatcher(link.left, MatchingConfig{}),
+				SourceID:     "src",
+				Target:       NewMatcher(link.right, MatchingConfig{}),
+				TargetID:     "dst",
+				BaselineFile: link.baselineFile,
+			})
+			if !errors.Is(err, ErrQuota) {
+				t.Fatalf("TwoWaySync = %v, want a quota error", err)
+			}
+			if link.right.searches != test.searches {
+				t.Errorf("%d searches made, want %d", link.right.searches, test.searches)
+			}
+			// Neither side is changed when one of them is over budget
+			if got := link.left.songs("src"); got != "abc" {
+				t.Errorf("source is %q, want it unchanged", got)
+			}
+			if got := link.right.songs("dst"); got != "d" {
+				t.Errorf("target is %q, want it unchanged", got)
+			}
+		})
+	}
+}
+
+func TestLongestIncreasing(t *testing.T) {
+	tests := []struct {
+		values []int
+		want   []int
+	}{
+		{nil, nil},
+		{[]int{0}, []int{0}},
+		{[]int{0, 1, 2, 3}, []int{0, 1, 2, 3}},
+		{[]int{3, 2, 1, 0}, []int{0}},
+		{[]int{4, 0, 1, 2, 3}, []int{0, 1, 2, 3}},
+		{[]int{1, 2, 3, 4, 0}, []int{1, 2, 3, 4}},
+		{[]int{0, 3, 1, 2, 4}, []int{0, 1, 2, 4}},
+		{[]int{2, 0, 1, 3}, []int{0, 1, 3}},
+	}
+	for _, test := range tests {
+		got := longestIncreasing(test.values)
+		var values []int
+		for _, value := range test.values {
+			if got[value] {
+				values = append(values, value)
+			}
+		}
+		if !slices.Equal(values, test.want) {
+			t.Errorf("longestIncreasing(%v) = %v, want %v", test.values, values, test.want)
+		}
+	}
+}
+
+// quotaService has run out of search quota.
+type quotaService struct {
+	*fakeService
+}
+
+func (q *quotaService) SearchTracks(ctx context.Context, track Track, limit int) ([]Track, error) {
+	q.searches++
+	return nil, &APIError{Service: q.name, StatusCode: 403, Status: "403 Forbidden", Message: "quota", Kind: ErrQuota}
+}
+
+func TestTwoWayStopsOnQuota(t *testing.T) {
+	link := newTwoWayLink(t)
+	link.left.set("src", "abcd")
+	link.right.set("dst", "ab")
+	right := &quotaService{fakeService: link.right}
+
+	err := TwoWaySync(context.Background(), TwoWayOptions{
+		Source:       NewMatcher(link.left, MatchingConfig{}),
+		SourceID:     "src",
+		Target:       NewMatcher(right, MatchingConfig{}),
+		TargetID:     "dst",
+		BaselineFile: link.baselineFile,
+	})
+	if !errors.Is(err, ErrQuota) {
+		t.Fatalf("TwoWaySync() = %v, want a quota error", err)
+	}
+	if right.searches != 1 {
+		t.Errorf("searched %d times after running out of quota, want 1", right.searches)
+	}
+	if left, right := link.left.songs("src"), link.right.songs("dst"); left != "abcd" || right != "ab" {
+		t.Errorf("playlists are %q and %q, want them unchanged", left, right)
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const youtubeAPI = "https://www.googleapis.com/youtube/v3"
+
+// Video IDs are 11 characters of base64url
+var youtubeID = regexp.MustCompile(`^[0-9A-Za-z_-]{11}$`)
+
+type YouTubeService struct {
+	config *Config
+	api    *APIClient
+}
+
+func init() {
+	RegisterService("youtube", NewYouTubeService)
+	RegisterOAuth("youtube", youtubeOAuth)
+	RegisterRateLimit("youtube", 5)
+	RegisterQuota("youtube", QuotaSpec{
+		Daily: 10000,
+		Units: map[string]int{
+			quotaSearch: 100,
+			quotaRead:   1,
+			quotaInsert: 50,
+			quotaUpdate: 50,
+			quotaDelete: 50,
+		},
+		CallType: youtubeCallType,
+	})
+}
+
+// youtubeCallType prices a request: searches are expensive, other calls
+// cost by method.
+func youtubeCallType(method string, url string) string {
+	switch {
+	case strings.HasPrefix(url, youtubeAPI+"/search"):
+		return quotaSearch
+	case method == "POST":
+		return quotaInsert
+	case method == "PUT":
+		return quotaUpdate
+	case method == "DELETE":
+		return quotaDelete
+	}
+	return quotaRead
+}
+
+func youtubeOAuth(config *Config) (*oauth2.Config, *OAuthToken) {
+	oauthConfig := &oauth2.Config{
+		ClientID:     config.YouTube.ClientID,
+		ClientSecret: config.YouTube.ClientSecret,
+		RedirectURL:  oauthRedirectURL,
+		Scopes:       []string{"https://www.googleapis.com/auth/youtube"},
+		Endpoint:     google.Endpoint,
+	}
+	return oauthConfig, &config.YouTube.OAuthToken
+}
+
+func NewYouTubeService(config *Config) MusicService {
+	return &YouTubeService{config: config, api: NewAPIClient("youtube", config, NewOAuthClient("youtube", config))}
+}
+
+func (y *YouTubeService) Name() string {
+	return "youtube"
+}
+
+func (y *YouTubeService) DisplayName() string {
+	return "YouTube"
+}
+
+// do sends a request that runs to completion, so a cancelled transfer
+// finishes the write in progress. Searches take a context instead.
+func (y *YouTubeService) do(method string, url string, body interface{}) (*http.Response, error) {
+	return y.api.Do(context.Background(), method, url, body)
+}
+
+func (y *YouTubeService) ValidateAuth() error {
+	if y.config.YouTube.OAuthToken.Empty() {
+		return y.reauthorize()
+	}
+
+	// Test token by making a request
+	resp, err := y.do("GET", youtubeAPI+"/channels?part=id&mine=true", nil)
+	if err != nil {
+		if isAuthError(err) {
+			return y.reauthorize()
+		}
+		return err
+	}
+	resp.Body.Close()
+
+	fmt.Println("Token is valid")
+	return nil
+}
+
+func (y *YouTubeService) reauthorize() error {
+	config, client, err := reauthorize("youtube", y.config.path)
+	if err != nil {
+		return err
+	}
+	y.config = config
+	y.api = NewAPIClient("youtube", config, client)
+	return nil
+}
+
+func (y *YouTubeService) ListPlaylists() ([]PlaylistSummary, error) {
+	resp, err := y.do("GET", youtubeAPI+"/playlists?part=snippet&mine=true", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	var playlists struct {
+		Items []struct {
+			Snippet struct {
+				Title string `json:"title"`
+			} `json:"snippet"`
+			Id string `json:"id"`
+		} `json:"items"`
+	}
+	if err := decodeResponse(resp, &playlists); err != nil {
+		return nil, err
+	}
+
+	var summaries []PlaylistSummary
+	for _, playlist := range playlists.Items {
+		summaries = append(summaries, PlaylistSummary{ID: playlist.Id, Name: playlist.Snippet.Title})
+	}
+	return summaries, nil
+}
+
+func (y *YouTubeService) ReadPlaylist(playlist string) (*Playlist, error) {
+	result := &Playlist{ID: playlist}
+
+	// Get playlist name
+	resp, err := y.do("GET", fmt.Sprintf("%s/playlists?part=snippet&id=%s", youtubeAPI, playlist), nil)
+	if err != nil {
+		return nil, err
+	}
+	var playlistData struct {
+		Items []struct {
+			Snippet struct {
+				Title string `json:"title"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := decodeResponse(resp, &playlistData); err != nil {
+		return nil, err
+	}
+	if len(playlistData.Items) > 0 {
+		result.Name = playlistData.Items[0].Snippet.Title
+	}
+
+	var nextPageToken string
+	for {
+		// Create request URL
+		listURL := fmt.Sprintf("%s/playlistItems?part=snippet,contentDetails&maxResults=50&playlistId=%s", youtubeAPI, playlist)
+		if nextPageToken != "" {
+			listURL = fmt.Sprintf("%s&pageToken=%s", listURL, nextPageToken)
+		}
+
+		resp, err := y.do("GET", listURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse response
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+			Items         []struct {
+				Id      string `json:"id"`
+				Snippet struct {
+					Title        string `json:"title"`
+					ChannelTitle string `json:"videoOwnerChannelTitle"`
+					ResourceId   struct {
+						VideoId string `json:"videoId"`
+					} `json:"resourceId"`
+				} `json:"snippet"`
+				ContentDetails struct {
+					VideoPublishedAt string `json:"videoPublishedAt"`
+				} `json:"contentDetails"`
+			} `json:"items"`
+		}
+		if err := decodeResponse(resp, &page); err != nil {
+			return nil, err
+		}
+
+		// Extract tracks from this page
+		var pageTracks []Track
+		for _, item := range page.Items {
+			videoId := item.Snippet.ResourceId.VideoId
+			track := Track{
+				ID:       videoId,
+				URI:      "https://www.youtube.com/watch?v=" + videoId,
+				ItemID:   item.Id,
+				Position: len(result.Tracks) + len(pageTracks),
+			}
+			track.Name, track.Artists = ParseVideoTitle(item.Snippet.Title, item.Snippet.ChannelTitle)
+			if published := item.ContentDetails.VideoPublishedAt; len(published) >= 4 {
+				track.ReleaseYear, _ = strconv.Atoi(published[:4])
+			}
+			pageTracks = append(pageTracks, track)
+		}
+
+		// Fill in durations, the playlist items endpoint does not return them
+		if err := y.fillDurations(context.Background(), pageTracks); err != nil {
+			return nil, err
+		}
+		result.Tracks = append(result.Tracks, pageTracks...)
+
+		nextPageToken = page.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (y *YouTubeService) fillDurations(ctx context.Context, tracks []Track) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	ids := make([]string, len(tracks))
+	for i, track := range tracks {
+		ids[i] = track.ID
+	}
+
+	resp, err := y.api.Do(ctx, "GET", fmt.Sprintf("%s/videos?part=contentDetails&id=%s", youtubeAPI, strings.Join(ids, ",")), nil)
+	if err != nil {
+		return err
+	}
+	var videos struct {
+		Items []struct {
+			Id             string `json:"id"`
+			ContentDetails struct {
+				Duration string `json:"duration"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+	if err := decodeResponse(resp, &videos); err != nil {
+		return err
+	}
+
+	durations := make(map[string]int)
+	for _, video := range videos.Items {
+		durations[video.Id] = parseISODuration(video.ContentDetails.Duration)
+	}
+	for i := range tracks {
+		tracks[i].DurationMs = durations[tracks[i].ID]
+	}
+	return nil
+}
+
+// parseISODuration converts YouTube's ISO 8601 durations such as PT4M13S
+// to milliseconds.
+func parseISODuration(duration string) int {
+	duration = strings.TrimPrefix(duration, "P")
+	total := 0
+	value := 0
+	inTime := false
+	for _, c := range duration {
+		switch {
+		case c >= '0' && c <= '9':
+			value = value*10 + int(c-'0')
+		case c == 'T':
+			inTime = true
+		case c == 'D':
+			total += value * 24 * 3600
+			value = 0
+		case c == 'H' && inTime:
+			total += value * 3600
+			value = 0
+		case c == 'M' && inTime:
+			total += value * 60
+			value = 0
+		case c == 'S' && inTime:
+			total += value
+			value = 0
+		default:
+			value = 0
+		}
+	}
+	return total * 1000
+}
+
+func (y *YouTubeService) SearchTracks(ctx context.Context, track Track, limit int) ([]Track, error) {
+	// Create search query
+	query := url.Values{}
+	query.Set("part", "snippet")
+	query.Set("maxResults", strconv.Itoa(limit))
+	query.Set("q", fmt.Sprintf("%s %s", track.Name, track.Artist()))
+	query.Set("type", "video")
+	query.Set("videoCategoryId", "10")
+
+	resp, err := y.api.Do(ctx, "GET", youtubeAPI+"/search?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	var result struct {
+		Items []struct {
+			Id struct {
+				VideoId string `json:"videoId"`
+			} `json:"id"`
+			Snippet struct {
+				Title        string `json:"title"`
+				ChannelTitle string `json:"channelTitle"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	for _, item := range result.Items {
+		candidate := Track{
+			ID:  item.Id.VideoId,
+			URI: "https://www.youtube.com/watch?v=" + item.Id.VideoId,
+		}
+		candidate.Name, candidate.Artists = ParseVideoTitle(item.Snippet.Title, item.Snippet.ChannelTitle)
+		tracks = append(tracks, candidate)
+	}
+
+	// Durations let the matcher tell edits and extended versions apart
+	if err := y.fillDurations(ctx, tracks); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+func (y *YouTubeService) CreatePlaylist(title string, description string, public bool) (string, error) {
+	privacyStatus := "private"
+	if public {
+		privacyStatus = "public"
+	}
+
+	requestBody := map[string]interface{}{
+		"snippet": map[string]interface{}{
+			"title":       title,
+			"description": description,
+		},
+		"status": map[string]interface{}{
+			"privacyStatus": privacyStatus,
+		},
+	}
+	resp, err := y.do("POST", youtubeAPI+"/playlists?part=snippet,status", requestBody)
+	if err != nil {
+		return "", fmt.Errorf("error creating playlist: %w", err)
+	}
+
+	var created struct {
+		Id string `json:"id"`
+	}
+	if err := decodeResponse(resp, &created); err != nil {
+		return "", err
+	}
+	fmt.Printf("Successfully created playlist %s\n", title)
+	return created.Id, nil
+}
+
+func (y *YouTubeService) ClearPlaylist(playlist string) error {
+	// Collect playlist item IDs, deleting while paging would shift the pages
+	var itemIds []string
+	var nextPageToken string
+	for {
+		listURL := fmt.Sprintf("%s/playlistItems?part=id&maxResults=50&playlistId=%s", youtubeAPI, playlist)
+		if nextPageToken != "" {
+			listURL = fmt.Sprintf("%s&pageToken=%s", listURL, nextPageToken)
+		}
+
+		resp, err := y.do("GET", listURL, nil)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+			Items         []struct {
+				Id string `json:"id"`
+			} `json:"items"`
+		}
+		if err := decodeResponse(resp, &page); err != nil {
+			return err
+		}
+
+		for _, item := range page.Items {
+			itemIds = append(itemIds, item.Id)
+		}
+		nextPageToken = page.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
+	}
+
+	// Delete items one at a time
+	tracks := make([]Track, len(itemIds))
+	for i, itemId := range itemIds {
+		tracks[i] = Track{ItemID: itemId}
+	}
+	if err := y.RemoveTracks(playlist, tracks); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully cleared playlist\n")
+	return nil
+}
+
+func (y *YouTubeService) AddTracks(playlist string, position int, tracks []Track) []BatchResult {
+	// The API has no bulk insert, so every track is its own batch
+	var results []BatchResult
+	for i := range tracks {
+		batch := BatchResult{Position: position, Tracks: tracks[i : i+1]}
+
+		snippet := map[string]interface{}{
+			"playlistId": playlist,
+			"resourceId": map[string]string{
+				"kind":    "youtube#video",
+				"videoId": tracks[i].ID,
+			},
+		}
+		if position >= 0 {
+			snippet["position"] = position
+		}
+
+		resp, err := y.do("POST", youtubeAPI+"/playlistItems?part=snippet", map[string]interface{}{"snippet": snippet})
+		if err != nil {
+			batch.Err = fmt.Errorf("error adding video: %w", err)
+		} else {
+			resp.Body.Close()
+		}
+
+		if batch.Err == nil && position >= 0 {
+			position++
+		}
+		results = append(results, batch)
+	}
+	return results
+}
+
+func (y *YouTubeService) BatchSize() int {
+	return 1
+}
+
+func (y *YouTubeService) RemoveTracks(playlist string, tracks []Track) error {
+	// Playlist items are deleted by item ID, one per request
+	for _, track := range tracks {
+		if track.ItemID == "" {
+			return fmt.Errorf("error removing %s: missing playlist item ID", track.ID)
+		}
+		resp, err := y.do("DELETE", fmt.Sprintf("%s/playlistItems?id=%s", youtubeAPI, track.ItemID), nil)
+		if err != nil {
+			return fmt.Errorf("error deleting playlist item %s: %w", track.ItemID, err)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+func (y *YouTubeService) MoveTrack(playlist string, track Track, from int, to int) error {
+	requestBody := map[string]interface{}{
+		"id": track.ItemID,
+		"snippet": map[string]interface{}{
+			"playlistId": playlist,
+			"position":   to,
+			"resourceId": map[string]string{
+				"kind":    "youtube#video",
+				"videoId": track.ID,
+			},
+		},
+	}
+	resp, err := y.do("PUT", youtubeAPI+"/playlistItems?part=snippet", requestBody)
+	if err != nil {
+		return fmt.Errorf("error moving video %s: %w", track.ID, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ParseTrackID reads a video ID from a youtube.com, music.youtube.com or
+// youtu.be link, a youtube.com/shorts link, or a bare ID.
+func (y *YouTubeService) ParseTrackID(input string) (string, bool) {
+	input = strings.TrimSpace(input)
+	if link, err := url.Parse(input); err == nil && link.Host != "" {
+		switch {
+		case link.Host == "youtu.be":
+			input = strings.Trim(link.Path, "/")
+		case strings.HasSuffix(link.Host, "youtube.com") && strings.HasPrefix(link.Path, "/shorts/"):
+			input = strings.Trim(strings.TrimPrefix(link.Path, "/shorts/"), "/")
+		case strings.HasSuffix(link.Host, "youtube.com"):
+			input = link.Query().Get("v")
+		default:
+			return "", false
+		}
+	}
+	return input, youtubeID.MatchString(input)
+}
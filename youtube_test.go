@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestYouTubeParseTrackID(t *testing.T) {
+	tests := []struct {
+		input string
+		id    string
+		ok    bool
+	}{
+		{"dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"  dQw4w9WgXcQ\n", "dQw4w9WgXcQ", true},
+
+		// Watch, short and music links
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123&t=42s", "dQw4w9WgXcQ", true},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&feature=share", "dQw4w9WgXcQ", true},
+		{"https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://youtube.com/shorts/dQw4w9WgXcQ?feature=share", "dQw4w9WgXcQ", true},
+
+		// Not a video
+		{"", "", false},
+		{"dQw4w9WgXc", "dQw4w9WgXc", false},
+		{"https://www.youtube.com/playlist?list=PL123", "", false},
+		{"https://www.youtube.com/shorts/", "", false},
+		{"https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC", "", false},
+	}
+
+	youtube := &YouTubeService{}
+	for _, test := range tests {
+		id, ok := youtube.ParseTrackID(test.input)
+		if id != test.id || ok != test.ok {
+			t.Errorf("ParseTrackID(%q) = %q, %v, want %q, %v", test.input, id, ok, test.id, test.ok)
+		}
+	}
+}